@@ -12,6 +12,7 @@ replace github.com/EnumeratedDev/bpm/src/bpmlib => ../bpmlib
 
 require (
 	github.com/drone/envsubst v1.0.3 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/knqyf263/go-rpm-version v0.0.0-20240918084003-2afd7dc6a38f // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
@@ -12,7 +12,9 @@ import (
 	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/EnumeratedDev/bpm/src/bpmlib"
@@ -33,8 +35,9 @@ var currentFlagSet *flag.FlagSet
 var exitCode = 0
 
 func main() {
+	// Read config unless checking it for problems
 	err := bpmlib.ReadConfig()
-	if err != nil {
+	if err != nil && (len(os.Args) < 2 || os.Args[1] != "config") {
 		log.Fatalf("Error: could not read BPM config: %s", err)
 	}
 
@@ -93,9 +96,23 @@ func main() {
 		currentFlagSet.BoolP("reinstall", "r", false, "Reinstall the specified packages")
 		currentFlagSet.IntP("jobs", "j", bpmlib.CompilationBPMConfig.CompilationJobs, "Set the amount of concurrent processes to use for source package compilation")
 		currentFlagSet.BoolP("skip-checks", "s", false, "Skip the check function in recipe.sh scripts")
+		currentFlagSet.Bool("offline", bpmlib.MainBPMConfig.Offline, "Only use packages available in local caches")
+		currentFlagSet.Bool("download-only", false, "Only fetch packages without installing them")
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Install the specified packages", os.Args[2:])
 
 		installPackages()
+	case "downgrade":
+		// Setup flags and help
+		currentFlagSet = flag.NewFlagSet("downgrade", flag.ExitOnError)
+		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
+		currentFlagSet.BoolP("verbose", "v", false, "Show additional information about the current operation")
+		currentFlagSet.BoolP("force", "f", false, "Bypass warnings during package installation")
+		currentFlagSet.BoolP("yes", "y", false, "Enter 'yes' in all prompts")
+		currentFlagSet.IntP("jobs", "j", bpmlib.CompilationBPMConfig.CompilationJobs, "Set the amount of concurrent processes to use for source package compilation")
+		currentFlagSet.BoolP("skip-checks", "s", false, "Skip the check function in recipe.sh scripts")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options> <package>", subcommand), "Install another cached version of the specified package", os.Args[2:])
+
+		downgradePackage()
 	case "r", "remove":
 		// Setup flags and help
 		currentFlagSet = flag.NewFlagSet("remove", flag.ExitOnError)
@@ -108,6 +125,13 @@ func main() {
 
 		removePackages()
 	case "n", "cleanup":
+		// Get cache retention defaults
+		keepVersions, uninstalledOnly := 3, false
+		if bpmlib.MainBPMConfig.CacheRetention != nil {
+			keepVersions = bpmlib.MainBPMConfig.CacheRetention.GetKeepVersions()
+			uninstalledOnly = bpmlib.MainBPMConfig.CacheRetention.UninstalledOnly
+		}
+
 		// Setup flags and help
 		currentFlagSet = flag.NewFlagSet("cleanup", flag.ExitOnError)
 		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
@@ -120,6 +144,9 @@ func main() {
 		currentFlagSet.BoolP("compilation-files", "c", false, "Perform a cleanup of compilation files")
 		currentFlagSet.BoolP("binary-packages", "b", false, "Perform a cleanup of compilation compiled binary packages")
 		currentFlagSet.BoolP("fetched-packages", "p", false, "Perform a cleanup of fetched packages from databases")
+		currentFlagSet.IntP("keep-versions", "k", keepVersions, "Remove cached packages except for the specified amount of newest versions of each package")
+		currentFlagSet.BoolP("uninstalled-only", "u", uninstalledOnly, "Only remove cached versions of packages that are not installed")
+		currentFlagSet.Bool("dry-run", false, "Show which cached packages would be removed without removing them")
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Remove unused dependencies, files and directories", os.Args[2:])
 
 		doCleanup()
@@ -129,6 +156,7 @@ func main() {
 		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
 		currentFlagSet.BoolP("verbose", "v", false, "Show additional information about the current operation")
 		currentFlagSet.BoolP("yes", "y", false, "Enter 'yes' in all prompts")
+		currentFlagSet.Bool("offline", bpmlib.MainBPMConfig.Offline, "Refuse to sync databases")
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Sync all databases", os.Args[2:])
 
 		syncDatabases()
@@ -143,6 +171,8 @@ func main() {
 		currentFlagSet.Bool("allow-downgrades", false, "Allow package downgrades")
 		currentFlagSet.BoolP("skip-checks", "s", false, "Skip the check function in recipe.sh scripts")
 		currentFlagSet.IntP("jobs", "j", bpmlib.CompilationBPMConfig.CompilationJobs, "Set the amount of concurrent processes to use for source package compilation")
+		currentFlagSet.Bool("offline", bpmlib.MainBPMConfig.Offline, "Only use packages available in local caches without syncing databases")
+		currentFlagSet.Bool("download-only", false, "Only fetch packages without installing them")
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Update installed packages", os.Args[2:])
 
 		updatePackages()
@@ -174,6 +204,55 @@ func main() {
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Compare two version numbers", os.Args[2:])
 
 		compareVersions()
+	case "repo":
+		currentFlagSet = flag.NewFlagSet("repo", flag.ExitOnError)
+		currentFlagSet.BoolP("verbose", "v", false, "Show additional information about what BPM is doing")
+		currentFlagSet.BoolP("sign", "s", false, "Sign the database and added packages using GPG")
+		currentFlagSet.StringP("key", "k", "", "Sign using the specified GPG key instead of the default one")
+		currentFlagSet.Duration("valid-for", 0, "Set how long the database is valid for before clients consider it expired (e.g. 168h)")
+		currentFlagSet.StringP("listen", "l", ":8080", "Set the address to serve the repository on")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <create|add|remove|serve> <options>", subcommand), "Create and manage package repositories", os.Args[2:])
+
+		manageRepository()
+	case "bundle":
+		currentFlagSet = flag.NewFlagSet("bundle", flag.ExitOnError)
+		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
+		currentFlagSet.BoolP("verbose", "v", false, "Show additional information about the current operation")
+		currentFlagSet.BoolP("force", "f", false, "Bypass warnings during package installation")
+		currentFlagSet.BoolP("yes", "y", false, "Enter 'yes' in all prompts")
+		currentFlagSet.StringP("output", "o", "bundle.bpmbundle", "Set the file to write the bundle to")
+		currentFlagSet.StringP("key", "k", "", "Sign using the specified GPG key instead of the default one")
+		currentFlagSet.Bool("import-keys", false, "Verify the bundle using its own public keys and import them into the keyring after confirmation")
+		currentFlagSet.BoolP("skip-checks", "s", false, "Skip the check function in recipe.sh scripts")
+		currentFlagSet.IntP("jobs", "j", bpmlib.CompilationBPMConfig.CompilationJobs, "Set the amount of concurrent processes to use for source package compilation")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <create|install> <options>", subcommand), "Create and install offline package bundles", os.Args[2:])
+
+		manageBundle()
+	case "history":
+		currentFlagSet = flag.NewFlagSet("history", flag.ExitOnError)
+		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
+		currentFlagSet.StringP("package", "p", "", "Only show operations affecting the specified package")
+		currentFlagSet.String("since", "", "Only show operations performed on or after the specified date (YYYY-MM-DD)")
+		currentFlagSet.String("until", "", "Only show operations performed on or before the specified date (YYYY-MM-DD)")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <list|show> <options>", subcommand), "Show previously performed operations", os.Args[2:])
+
+		showHistory()
+	case "snapshot":
+		currentFlagSet = flag.NewFlagSet("snapshot", flag.ExitOnError)
+		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
+		currentFlagSet.BoolP("yes", "y", false, "Enter 'yes' in all prompts")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <list|restore> <options>", subcommand), "List and restore snapshots taken before operations", os.Args[2:])
+
+		manageSnapshots()
+	case "undo":
+		currentFlagSet = flag.NewFlagSet("undo", flag.ExitOnError)
+		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
+		currentFlagSet.BoolP("verbose", "v", false, "Show additional information about the current operation")
+		currentFlagSet.BoolP("force", "f", false, "Bypass warnings during the operation")
+		currentFlagSet.BoolP("yes", "y", false, "Enter 'yes' in all prompts")
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options> [id]", subcommand), "Revert the last or specified operation in the history", os.Args[2:])
+
+		undoOperation()
 	case "keyring":
 		currentFlagSet = flag.NewFlagSet("keyring", flag.ExitOnError)
 		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
@@ -187,6 +266,11 @@ func main() {
 		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <options>", subcommand), "Manage the BPM keyring", os.Args[2:])
 
 		manageKeyring()
+	case "config":
+		currentFlagSet = flag.NewFlagSet("config", flag.ExitOnError)
+		setupFlagsAndHelp(currentFlagSet, fmt.Sprintf("bpm %s <check>", subcommand), "Manage the BPM configuration", os.Args[2:])
+
+		manageConfig()
 	case "upgrade-persistent-data":
 		currentFlagSet = flag.NewFlagSet("upgrade-persistent-data", flag.ExitOnError)
 		currentFlagSet.StringP("root", "R", "/", "Operate on specified root directory")
@@ -577,8 +661,17 @@ func installPackages() {
 	installationReason, _ := currentFlagSet.GetString("installation-reason")
 	reinstallPackages, _ := currentFlagSet.GetBool("reinstall")
 	skipChecks, _ := currentFlagSet.GetBool("skip-checks")
+	offline, _ := currentFlagSet.GetBool("offline")
+	downloadOnly, _ := currentFlagSet.GetBool("download-only")
 	compilationJobs, _ := currentFlagSet.GetInt("jobs")
 
+	// Ensure packages can be downloaded
+	if downloadOnly && offline {
+		log.Printf("Error: cannot download packages in offline mode")
+		exitCode = 1
+		return
+	}
+
 	// Get packages
 	packages := currentFlagSet.Args()
 	if len(packages) == 0 {
@@ -635,8 +728,17 @@ func installPackages() {
 	}
 
 	// Create installation operation
-	operation, err := bpmlib.InstallPackages(rootDir, ir, reinstallPackages, installRuntime, force, !skipChecks, verbose, packages...)
-	if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) {
+	operation, err := bpmlib.InstallPackages(rootDir, ir, reinstallPackages, installRuntime, force, !skipChecks, offline, verbose, packages...)
+	if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) || errors.As(err, &bpmlib.PackagesUnavailableOfflineErr{}) {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if errors.As(err, &bpmlib.PackageDependantVersionErr{}) {
+		for pkg, dependants := range err.(bpmlib.PackageDependantVersionErr).BrokenDependants {
+			slices.Sort(dependants)
+			fmt.Printf("The following packages require a different version of package (%s): %s\n", pkg, strings.Join(dependants, ", "))
+		}
+
 		log.Printf("Error: %s", err)
 		exitCode = 1
 		return
@@ -649,100 +751,148 @@ func installPackages() {
 	// Set compilation job count
 	operation.CompilationJobs = compilationJobs
 
-	// Exit if operation contains no actions
-	if len(operation.Actions) == 0 {
-		fmt.Println("No action needs to be taken")
-		return
+	// Run operation
+	if !runOperation(operation, operationOptions{
+		verb:               "install",
+		cancelMessage:      "Cancelling package installation...",
+		showSourceContents: bpmlib.MainBPMConfig.ShowSourcePackageContents == "always" || bpmlib.MainBPMConfig.ShowSourcePackageContents == "install-only",
+		downloadOnly:       downloadOnly,
+		verbose:            verbose,
+		force:              force,
+		yesAll:             yesAll,
+	}) {
+		exitCode = 1
 	}
+}
 
-	// Show operation summary
-	operation.ShowOperationSummary()
+func downgradePackage() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
+	verbose, _ := currentFlagSet.GetBool("verbose")
+	force, _ := currentFlagSet.GetBool("force")
+	yesAll, _ := currentFlagSet.GetBool("yes")
+	skipChecks, _ := currentFlagSet.GetBool("skip-checks")
+	compilationJobs, _ := currentFlagSet.GetInt("jobs")
 
-	// Confirmation Prompt
-	if !yesAll {
-		prompt := "Do you wish to install this package?"
-		if len(operation.Actions) != 1 {
-			prompt = fmt.Sprintf("Do you wish to install all %d packages?", len(operation.Actions))
-		}
+	// Get package
+	if currentFlagSet.NArg() != 1 {
+		fmt.Println("A single package must be given to downgrade")
+		exitCode = 1
+		return
+	}
+	pkgName := currentFlagSet.Arg(0)
 
-		if !showConfirmationPrompt(prompt, false) {
-			fmt.Println("Cancelling package installation...")
-			exitCode = 1
-			return
-		}
+	// Check for required permissions
+	if os.Getuid() != 0 {
+		log.Printf("Error: this subcommand needs to be run with superuser permissions")
+		exitCode = 1
+		return
 	}
 
-	// Fetch packages
-	err = operation.FetchPackages()
+	// Create BPM Lock file
+	fileLock, err := bpmlib.LockBPM(rootDir)
 	if err != nil {
-		log.Printf("Error: could not fetch packages for operation: %s\n", err)
+		log.Printf("Error: could not create BPM lock file: %s", err)
 		exitCode = 1
 		return
 	}
+	defer fileLock.Unlock()
 
-	// Get files that will be modifie during this operation
-	operation.GetModifiedFiles()
-
-	if bpmlib.MainBPMConfig.ShowSourcePackageContents == "always" || bpmlib.MainBPMConfig.ShowSourcePackageContents == "install-only" {
-		// Show source package contents
-		sourcePackagesShown, err := operation.ShowSourcePackageContent()
-		if err != nil {
-			log.Printf("Error: could not show source package content: %s\n", err)
-			exitCode = 1
-			return
-		}
-
-		// Confirmation Prompt
-		if sourcePackagesShown > 0 && !yesAll {
-			if !showConfirmationPrompt("Do you wish to continue?", false) {
-				fmt.Println("Cancelling package installation...")
-				exitCode = 1
-				return
-			}
-		}
+	// Initialize installed packages map
+	err = bpmlib.InitializeLocalPackageInformation(rootDir)
+	if err != nil {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
 	}
 
-	// Get optional dependencies
-	optionalDepends := operation.GetOptionalDependencies()
-
-	// Executing pre-operation hooks
-	fmt.Println("Running pre-operation hooks...")
-	err = operation.RunPreHooks(verbose)
+	// Read local databases
+	err = bpmlib.ReadLocalDatabaseFiles()
 	if err != nil {
-		log.Printf("Error: could not run pre-operation hooks: %s\n", err)
+		log.Printf("Error: could not read local databases: %s", err)
 		exitCode = 1
 		return
 	}
 
-	// Execute operation
-	err = operation.Execute(verbose, force)
-	if err != nil {
-		log.Printf("Error: could not complete operation: %s\n", err)
+	// Get installed package information
+	installedInfo := bpmlib.GetPackageInfo(pkgName, rootDir)
+	if installedInfo == nil {
+		log.Printf("Error: package (%s) is not installed", pkgName)
 		exitCode = 1
 		return
 	}
 
-	// Executing post-operation hooks
-	fmt.Println("Running post-operation hooks...")
-	err = operation.RunPostHooks(verbose)
+	// Get other cached versions of package
+	versions, err := bpmlib.GetCachedPackageVersions(rootDir, pkgName)
 	if err != nil {
-		log.Printf("Error: could not run post-operation hooks: %s\n", err)
+		log.Printf("Error: could not get cached versions of package (%s): %s", pkgName, err)
+		exitCode = 1
+		return
+	}
+	versions = slices.DeleteFunc(versions, func(cachedPackage bpmlib.CachedPackage) bool {
+		return cachedPackage.Version == installedInfo.GetFullVersion()
+	})
+	if len(versions) == 0 {
+		fmt.Printf("No other cached versions of package (%s) were found\n", pkgName)
 		exitCode = 1
 		return
 	}
 
-	fmt.Println("Operation complete!")
+	// Show cached versions
+	fmt.Printf("Installed version of package (%s): %s\n", pkgName, installedInfo.GetFullVersion())
+	fmt.Println("Cached versions:")
+	for i, cachedPackage := range versions {
+		if bpmlib.CompareVersions(cachedPackage.Version, installedInfo.GetFullVersion()) > 0 {
+			fmt.Printf("  %d) %s (newer)\n", i+1, cachedPackage.Version)
+		} else {
+			fmt.Printf("  %d) %s\n", i+1, cachedPackage.Version)
+		}
+	}
 
-	// Show optional dependencies
-	if len(optionalDepends) != 0 {
-		// List optional dependencies
-		fmt.Println("The following optional dependenices have been discovered:")
-		for dependant, depends := range optionalDepends {
-			fmt.Printf("%s: \n", dependant)
-			for _, depend := range depends {
-				fmt.Printf("  - %s\n", depend)
-			}
+	// Select version to install
+	fmt.Printf("Select a version to install [1-%d]: ", len(versions))
+	text, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	selection, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || selection < 1 || selection > len(versions) {
+		log.Printf("Error: invalid selection (%s)", strings.TrimSpace(text))
+		exitCode = 1
+		return
+	}
+	selected := versions[selection-1]
+
+	// Create installation operation
+	operation, err := bpmlib.InstallPackages(rootDir, bpmlib.InstallationReasonUnknown, false, true, force, !skipChecks, false, verbose, selected.Filepath)
+	if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if errors.As(err, &bpmlib.PackageDependantVersionErr{}) {
+		for pkg, dependants := range err.(bpmlib.PackageDependantVersionErr).BrokenDependants {
+			slices.Sort(dependants)
+			fmt.Printf("The following packages require a different version of package (%s): %s\n", pkg, strings.Join(dependants, ", "))
 		}
+
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if err != nil {
+		log.Printf("Error: could not setup operation: %s\n", err)
+		exitCode = 1
+		return
+	}
+
+	// Set compilation job count
+	operation.CompilationJobs = compilationJobs
+
+	// Run operation
+	if !runOperation(operation, operationOptions{
+		verb:          "install",
+		cancelMessage: "Cancelling package installation...",
+		verbose:       verbose,
+		force:         force,
+		yesAll:        yesAll,
+	}) {
+		exitCode = 1
 	}
 }
 
@@ -877,6 +1027,10 @@ func doCleanup() {
 	cleanupCompilationFiles, _ := currentFlagSet.GetBool("compilation-files")
 	cleanupBinaryPackages, _ := currentFlagSet.GetBool("binary-packages")
 	cleanupFetchedPackages, _ := currentFlagSet.GetBool("fetched-packages")
+	keepVersions, _ := currentFlagSet.GetInt("keep-versions")
+	uninstalledOnly, _ := currentFlagSet.GetBool("uninstalled-only")
+	dryRun, _ := currentFlagSet.GetBool("dry-run")
+	cleanupOldPackages := isFlagSet(currentFlagSet, "keep-versions") || isFlagSet(currentFlagSet, "uninstalled-only") || dryRun
 
 	// Set default behaviour
 	if all {
@@ -885,7 +1039,7 @@ func doCleanup() {
 		cleanupCompilationFiles = true
 		cleanupBinaryPackages = true
 		cleanupFetchedPackages = true
-	} else if !isFlagSet(currentFlagSet, "depends") && !isFlagSet(currentFlagSet, "make-depends") && !isFlagSet(currentFlagSet, "compilation-files") && !isFlagSet(currentFlagSet, "binary-packages") && !isFlagSet(currentFlagSet, "fetched-packages") {
+	} else if !isFlagSet(currentFlagSet, "depends") && !isFlagSet(currentFlagSet, "make-depends") && !isFlagSet(currentFlagSet, "compilation-files") && !isFlagSet(currentFlagSet, "binary-packages") && !isFlagSet(currentFlagSet, "fetched-packages") && !cleanupOldPackages {
 		cleanupDepends = true
 		cleanupMakeDepends = bpmlib.MainBPMConfig.CleanupMakeDependencies
 		cleanupCompilationFiles = false
@@ -917,6 +1071,40 @@ func doCleanup() {
 		return
 	}
 
+	if keepVersions < 0 {
+		log.Printf("Error: amount of versions to keep must not be negative")
+		exitCode = 1
+		return
+	}
+
+	// Remove old versions of cached packages
+	if cleanupOldPackages {
+		expired, err := bpmlib.GetExpiredCachedPackages(rootDir, keepVersions, uninstalledOnly)
+		if err != nil {
+			log.Printf("Error: could not get cached packages: %s", err)
+			exitCode = 1
+			return
+		}
+
+		if dryRun {
+			bpmlib.ShowCachedPackages(expired)
+			return
+		}
+
+		err = bpmlib.RemoveCachedPackages(expired, verbose)
+		if err != nil {
+			log.Printf("Error: could not remove cached packages: %s", err)
+			exitCode = 1
+			return
+		}
+
+		var size int64
+		for _, cachedPackage := range expired {
+			size += cachedPackage.Size
+		}
+		fmt.Printf("Removed %d cached packages (%s)\n", len(expired), bpmlib.BytesToHumanReadable(size))
+	}
+
 	err = bpmlib.CleanupCache(rootDir, cleanupCompilationFiles, cleanupBinaryPackages, cleanupFetchedPackages, verbose)
 	if err != nil {
 		log.Printf("Error: could not complete cache cleanup: %s", err)
@@ -1006,6 +1194,14 @@ func syncDatabases() {
 	rootDir, _ := currentFlagSet.GetString("root")
 	verbose, _ := currentFlagSet.GetBool("verbose")
 	yesAll, _ := currentFlagSet.GetBool("yes")
+	offline, _ := currentFlagSet.GetBool("offline")
+
+	// Refuse to sync databases when offline
+	if offline {
+		log.Printf("Error: cannot sync databases in offline mode")
+		exitCode = 1
+		return
+	}
 
 	// Check for required permissions
 	if os.Getuid() != 0 {
@@ -1033,10 +1229,18 @@ func syncDatabases() {
 	}
 
 	// Sync databases
-	err = bpmlib.SyncDatabase(verbose)
+	results, err := bpmlib.SyncDatabase(verbose)
+	bpmlib.ShowSyncResults(results)
 	if err != nil {
 		log.Printf("Error: could not sync local database: %s\n", err)
-		exitCode = 1
+
+		// Use a distinct exit code if only some databases failed to sync
+		var syncErr bpmlib.DatabaseSyncErr
+		if errors.As(err, &syncErr) && len(syncErr.Failed) < syncErr.Total {
+			exitCode = 2
+		} else {
+			exitCode = 1
+		}
 		return
 	}
 
@@ -1053,6 +1257,20 @@ func updatePackages() {
 	allowDowngrades, _ := currentFlagSet.GetBool("allow-downgrades")
 	skipChecks, _ := currentFlagSet.GetBool("skip-checks")
 	compilationJobs, _ := currentFlagSet.GetInt("jobs")
+	offline, _ := currentFlagSet.GetBool("offline")
+	downloadOnly, _ := currentFlagSet.GetBool("download-only")
+
+	// Ensure packages can be downloaded
+	if downloadOnly && offline {
+		log.Printf("Error: cannot download packages in offline mode")
+		exitCode = 1
+		return
+	}
+
+	// Do not sync databases when offline
+	if offline {
+		noSync = true
+	}
 
 	// Check for required permissions
 	if os.Getuid() != 0 {
@@ -1098,8 +1316,17 @@ func updatePackages() {
 	}
 
 	// Create update operation
-	operation, err := bpmlib.UpdatePackages(rootDir, !noSync, allowDowngrades, force, !skipChecks, verbose)
-	if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) {
+	operation, err := bpmlib.UpdatePackages(rootDir, !noSync, allowDowngrades, force, !skipChecks, offline, verbose)
+	if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) || errors.As(err, &bpmlib.PackagesUnavailableOfflineErr{}) {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if errors.As(err, &bpmlib.PackageDependantVersionErr{}) {
+		for pkg, dependants := range err.(bpmlib.PackageDependantVersionErr).BrokenDependants {
+			slices.Sort(dependants)
+			fmt.Printf("The following packages require a different version of package (%s): %s\n", pkg, strings.Join(dependants, ", "))
+		}
+
 		log.Printf("Error: %s", err)
 		exitCode = 1
 		return
@@ -1112,137 +1339,54 @@ func updatePackages() {
 	// Set compilation job count
 	operation.CompilationJobs = compilationJobs
 
-	// Exit if operation contains no actions
-	if len(operation.Actions) == 0 {
-		fmt.Println("No action needs to be taken")
-		return
+	// Run operation
+	if !runOperation(operation, operationOptions{
+		verb:               "update",
+		cancelMessage:      "Cancelling package update...",
+		showSourceContents: bpmlib.MainBPMConfig.ShowSourcePackageContents == "always",
+		downloadOnly:       downloadOnly,
+		verbose:            verbose,
+		force:              force,
+		yesAll:             yesAll,
+	}) {
+		exitCode = 1
 	}
+}
 
-	// Show operation summary
-	operation.ShowOperationSummary()
-
-	// Confirmation Prompt
-	if !yesAll {
-		prompt := "Do you wish to update this package?"
-		if len(operation.Actions) != 1 {
-			prompt = fmt.Sprintf("Do you wish to update all %d packages?", len(operation.Actions))
-		}
-
-		if !showConfirmationPrompt(prompt, false) {
-			fmt.Println("Cancelling package update...")
-			exitCode = 1
-			return
-		}
-	}
+func getPathOwners() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
 
-	// Fetch packages
-	err = operation.FetchPackages()
+	// Initialize installed packages map
+	err := bpmlib.InitializeLocalPackageInformation(rootDir)
 	if err != nil {
-		log.Printf("Error: could not fetch packages for operation: %s\n", err)
+		log.Printf("Error: %s", err)
 		exitCode = 1
 		return
 	}
 
-	// Get files that will be modifie during this operation
-	operation.GetModifiedFiles()
+	// Get files
+	files := currentFlagSet.Args()
+	if len(files) == 0 {
+		fmt.Println("No files were given to get which packages own it")
+		return
+	}
 
-	if bpmlib.MainBPMConfig.ShowSourcePackageContents == "always" {
-		// Show source package contents
-		sourcePackagesShown, err := operation.ShowSourcePackageContent()
-		if err != nil {
-			log.Printf("Error: could not show source package content: %s\n", err)
+	for _, path := range files {
+		// Ensure file exists
+		stat, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			log.Printf("Error: %s", err)
 			exitCode = 1
 			return
 		}
 
-		// Confirmation Prompt
-		if sourcePackagesShown > 0 && !yesAll {
-			if !showConfirmationPrompt("Do you wish to continue?", false) {
-				fmt.Println("Cancelling package installation...")
-				exitCode = 1
-				return
-			}
-		}
-	}
-
-	// Get optional dependencies
-	optionalDepends := operation.GetOptionalDependencies()
-
-	// Executing pre-operation hooks
-	fmt.Println("Running pre-operation hooks...")
-	err = operation.RunPreHooks(verbose)
-	if err != nil {
-		log.Printf("Error: could not run pre-operation hooks: %s\n", err)
-		exitCode = 1
-		return
-	}
-
-	// Execute operation
-	err = operation.Execute(verbose, force)
-	if err != nil {
-		log.Printf("Error: could not complete operation: %s\n", err)
-		exitCode = 1
-		return
-	}
-
-	// Executing post-operation hooks
-	fmt.Println("Running post-operation hooks...")
-	err = operation.RunPostHooks(verbose)
-	if err != nil {
-		log.Printf("Error: could not run post-operation hooks: %s\n", err)
-		exitCode = 1
-		return
-	}
-
-	fmt.Println("Operation complete!")
-
-	// Show optional dependencies
-	if len(optionalDepends) != 0 {
-		// List optional dependencies
-		fmt.Println("The following optional dependenices have been discovered:")
-		for dependant, depends := range optionalDepends {
-			fmt.Printf("%s: \n", dependant)
-			for _, depend := range depends {
-				fmt.Printf("  - %s\n", depend)
-			}
-		}
-	}
-}
-
-func getPathOwners() {
-	// Get flags
-	rootDir, _ := currentFlagSet.GetString("root")
-
-	// Initialize installed packages map
-	err := bpmlib.InitializeLocalPackageInformation(rootDir)
-	if err != nil {
-		log.Printf("Error: %s", err)
-		exitCode = 1
-		return
-	}
-
-	// Get files
-	files := currentFlagSet.Args()
-	if len(files) == 0 {
-		fmt.Println("No files were given to get which packages own it")
-		return
-	}
-
-	for _, path := range files {
-		// Ensure file exists
-		stat, err := os.Lstat(path)
-		if os.IsNotExist(err) {
-			log.Printf("Error: %s", err)
-			exitCode = 1
-			return
-		}
-
-		// Get path type
-		pathType := "File"
-		if stat.IsDir() {
-			pathType = "Directory"
-		} else if stat.Mode()&os.ModeSymlink != 0 {
-			pathType = "Symlink"
+		// Get path type
+		pathType := "File"
+		if stat.IsDir() {
+			pathType = "Directory"
+		} else if stat.Mode()&os.ModeSymlink != 0 {
+			pathType = "Symlink"
 		}
 
 		pathOwners, err := bpmlib.GetPathOwners(path, rootDir)
@@ -1639,6 +1783,549 @@ func manageKeyring() {
 	}
 }
 
+func manageRepository() {
+	// Get flags
+	verbose, _ := currentFlagSet.GetBool("verbose")
+	sign, _ := currentFlagSet.GetBool("sign")
+	signingKey, _ := currentFlagSet.GetString("key")
+	validFor, _ := currentFlagSet.GetDuration("valid-for")
+	listenAddr, _ := currentFlagSet.GetString("listen")
+
+	switch currentFlagSet.Arg(0) {
+	case "create":
+		if currentFlagSet.NArg() != 2 {
+			log.Printf("Error: usage: bpm repo create <directory>")
+			exitCode = 1
+			return
+		}
+
+		err := bpmlib.CreateRepository(currentFlagSet.Arg(1), sign, signingKey, validFor, verbose)
+		if err != nil {
+			log.Printf("Error: could not create repository: %s", err)
+			exitCode = 1
+			return
+		}
+		fmt.Println("Repository database created successfully!")
+	case "add":
+		if currentFlagSet.NArg() < 3 {
+			log.Printf("Error: usage: bpm repo add <database> <packages...>")
+			exitCode = 1
+			return
+		}
+
+		err := bpmlib.AddToRepository(currentFlagSet.Arg(1), currentFlagSet.Args()[2:], sign, signingKey, validFor, verbose)
+		if err != nil {
+			log.Printf("Error: could not add packages to repository: %s", err)
+			exitCode = 1
+			return
+		}
+		fmt.Println("Packages added to repository successfully!")
+	case "remove":
+		if currentFlagSet.NArg() < 3 {
+			log.Printf("Error: usage: bpm repo remove <database> <packages...>")
+			exitCode = 1
+			return
+		}
+
+		err := bpmlib.RemoveFromRepository(currentFlagSet.Arg(1), currentFlagSet.Args()[2:], sign, signingKey, validFor, verbose)
+		if err != nil {
+			log.Printf("Error: could not remove packages from repository: %s", err)
+			exitCode = 1
+			return
+		}
+		fmt.Println("Packages removed from repository successfully!")
+	case "serve":
+		if currentFlagSet.NArg() != 2 {
+			log.Printf("Error: usage: bpm repo serve <directory>")
+			exitCode = 1
+			return
+		}
+
+		err := bpmlib.ServeRepository(currentFlagSet.Arg(1), listenAddr, verbose)
+		if err != nil {
+			log.Printf("Error: could not serve repository: %s", err)
+			exitCode = 1
+			return
+		}
+	default:
+		currentFlagSet.Usage()
+		exitCode = 1
+	}
+}
+
+func showHistory() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
+	pkg, _ := currentFlagSet.GetString("package")
+	since, _ := currentFlagSet.GetString("since")
+	until, _ := currentFlagSet.GetString("until")
+
+	format := "02/01/2006 15:04"
+
+	switch currentFlagSet.Arg(0) {
+	case "", "list":
+		// Parse date filters
+		var sinceTime, untilTime time.Time
+		if since != "" {
+			var err error
+			sinceTime, err = time.ParseInLocation(time.DateOnly, since, time.Local)
+			if err != nil {
+				log.Printf("Error: invalid date (%s): expected YYYY-MM-DD", since)
+				exitCode = 1
+				return
+			}
+		}
+		if until != "" {
+			var err error
+			untilTime, err = time.ParseInLocation(time.DateOnly, until, time.Local)
+			if err != nil {
+				log.Printf("Error: invalid date (%s): expected YYYY-MM-DD", until)
+				exitCode = 1
+				return
+			}
+			untilTime = untilTime.AddDate(0, 0, 1)
+		}
+
+		history, err := bpmlib.GetHistory(rootDir)
+		if err != nil {
+			log.Printf("Error: could not read history: %s", err)
+			exitCode = 1
+			return
+		}
+
+		// Filter history entries
+		history = slices.DeleteFunc(history, func(entry *bpmlib.HistoryEntry) bool {
+			timestamp := time.Unix(entry.Timestamp, 0)
+			return (pkg != "" && !entry.ContainsPackage(pkg)) ||
+				(since != "" && timestamp.Before(sinceTime)) ||
+				(until != "" && !timestamp.Before(untilTime))
+		})
+		if len(history) == 0 {
+			fmt.Println("No operations were found")
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 6, 4, 6, ' ', 0)
+		fmt.Fprintln(writer, "ID\tDate\tUser\tActions\tOutcome\tCommand")
+		for _, entry := range history {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%d\t%s\t%s\n", entry.ID, time.Unix(entry.Timestamp, 0).Format(format), entry.User, len(entry.Actions), entry.GetOutcome(), strings.Join(entry.CommandLine, " "))
+		}
+		writer.Flush()
+	case "show":
+		if currentFlagSet.NArg() != 2 {
+			log.Printf("Error: usage: bpm history show <id>")
+			exitCode = 1
+			return
+		}
+		id, err := strconv.Atoi(currentFlagSet.Arg(1))
+		if err != nil {
+			log.Printf("Error: invalid history entry ID (%s)", currentFlagSet.Arg(1))
+			exitCode = 1
+			return
+		}
+
+		entry, err := bpmlib.GetHistoryEntry(rootDir, id)
+		if err != nil {
+			log.Printf("Error: %s", err)
+			exitCode = 1
+			return
+		}
+
+		fmt.Printf("ID: %d\n", entry.ID)
+		fmt.Printf("Date: %s\n", time.Unix(entry.Timestamp, 0).Format(format))
+		fmt.Printf("User: %s\n", entry.User)
+		fmt.Printf("Command: %s\n", strings.Join(entry.CommandLine, " "))
+		fmt.Printf("Outcome: %s\n", entry.GetOutcome())
+		if entry.Snapshot != 0 {
+			fmt.Printf("Snapshot: %d\n", entry.Snapshot)
+		}
+		fmt.Println("Actions:")
+
+		writer := tabwriter.NewWriter(os.Stdout, 6, 4, 6, ' ', 0)
+		fmt.Fprintln(writer, "Action\tPackage\tOld Version\tNew Version\tOutcome")
+		for _, action := range entry.Actions {
+			oldVersion, newVersion := action.OldVersion, action.NewVersion
+			if oldVersion == "" {
+				oldVersion = "-"
+			}
+			if newVersion == "" {
+				newVersion = "-"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", action.Type, action.Package, oldVersion, newVersion, action.Outcome)
+		}
+		writer.Flush()
+
+		// Show errors of failed actions
+		for _, action := range entry.Actions {
+			if action.Error != "" {
+				fmt.Printf("Error for package (%s): %s\n", action.Package, strings.TrimSpace(action.Error))
+			}
+		}
+	default:
+		currentFlagSet.Usage()
+		exitCode = 1
+	}
+}
+
+func manageSnapshots() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
+	yesAll, _ := currentFlagSet.GetBool("yes")
+
+	switch currentFlagSet.Arg(0) {
+	case "", "list":
+		snapshots, err := bpmlib.GetSnapshots(rootDir)
+		if err != nil {
+			log.Printf("Error: could not read snapshots: %s", err)
+			exitCode = 1
+			return
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No snapshots were found")
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 6, 4, 6, ' ', 0)
+		fmt.Fprintln(writer, "ID\tDate\tBackend\tLabel")
+		for _, snapshot := range snapshots {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", snapshot.ID, time.Unix(snapshot.Timestamp, 0).Format("02/01/2006 15:04"), snapshot.Backend, snapshot.Label)
+		}
+		writer.Flush()
+	case "restore":
+		if currentFlagSet.NArg() != 2 {
+			log.Printf("Error: usage: bpm snapshot restore <id>")
+			exitCode = 1
+			return
+		}
+		id, err := strconv.Atoi(currentFlagSet.Arg(1))
+		if err != nil {
+			log.Printf("Error: invalid snapshot ID (%s)", currentFlagSet.Arg(1))
+			exitCode = 1
+			return
+		}
+
+		// Check for required permissions
+		if os.Getuid() != 0 {
+			log.Printf("Error: this subcommand needs to be run with superuser permissions")
+			exitCode = 1
+			return
+		}
+
+		// Create BPM Lock file
+		fileLock, err := bpmlib.LockBPM(rootDir)
+		if err != nil {
+			log.Printf("Error: could not create BPM lock file: %s", err)
+			exitCode = 1
+			return
+		}
+		defer fileLock.Unlock()
+
+		snapshot, err := bpmlib.GetSnapshot(rootDir, id)
+		if err != nil {
+			log.Printf("Error: %s", err)
+			exitCode = 1
+			return
+		}
+
+		// Confirmation Prompt
+		fmt.Printf("Snapshot (%d) was taken on %s before: %s\n", snapshot.ID, time.Unix(snapshot.Timestamp, 0).Format("02/01/2006 15:04"), snapshot.Label)
+		if !yesAll && !showConfirmationPrompt("Do you wish to restore this snapshot?", false) {
+			fmt.Println("Cancelling snapshot restoration...")
+			exitCode = 1
+			return
+		}
+
+		err = bpmlib.RestoreSnapshot(rootDir, id)
+		if err != nil {
+			log.Printf("Error: could not restore snapshot (%d): %s", id, err)
+			exitCode = 1
+			return
+		}
+
+		fmt.Println("Snapshot restored successfully!")
+	default:
+		currentFlagSet.Usage()
+		exitCode = 1
+	}
+}
+
+func undoOperation() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
+	verbose, _ := currentFlagSet.GetBool("verbose")
+	force, _ := currentFlagSet.GetBool("force")
+	yesAll, _ := currentFlagSet.GetBool("yes")
+
+	// Check for required permissions
+	if os.Getuid() != 0 {
+		log.Printf("Error: this subcommand needs to be run with superuser permissions")
+		exitCode = 1
+		return
+	}
+
+	// Create BPM Lock file
+	fileLock, err := bpmlib.LockBPM(rootDir)
+	if err != nil {
+		log.Printf("Error: could not create BPM lock file: %s", err)
+		exitCode = 1
+		return
+	}
+	defer fileLock.Unlock()
+
+	// Initialize installed packages map
+	err = bpmlib.InitializeLocalPackageInformation(rootDir)
+	if err != nil {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	}
+
+	// Get ID of operation to undo
+	var id int
+	if currentFlagSet.NArg() > 0 {
+		id, err = strconv.Atoi(currentFlagSet.Arg(0))
+		if err != nil {
+			log.Printf("Error: invalid history entry ID (%s)", currentFlagSet.Arg(0))
+			exitCode = 1
+			return
+		}
+	} else {
+		history, err := bpmlib.GetHistory(rootDir)
+		if err != nil {
+			log.Printf("Error: could not read history: %s", err)
+			exitCode = 1
+			return
+		}
+		if len(history) == 0 {
+			fmt.Println("No operations have been recorded")
+			return
+		}
+		id = history[len(history)-1].ID
+	}
+
+	// Create undo operation
+	operation, err := bpmlib.UndoOperation(rootDir, id, force)
+	if errors.As(err, &bpmlib.PackageVersionsNotCachedErr{}) || errors.As(err, &bpmlib.PackagesChangedSinceOperationErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) {
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if errors.As(err, &bpmlib.PackageDependantVersionErr{}) {
+		for pkg, dependants := range err.(bpmlib.PackageDependantVersionErr).BrokenDependants {
+			slices.Sort(dependants)
+			fmt.Printf("The following packages require a different version of package (%s): %s\n", pkg, strings.Join(dependants, ", "))
+		}
+
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if errors.As(err, &bpmlib.PackageRemovalDependencyErr{}) {
+		for pkg, dependants := range err.(bpmlib.PackageRemovalDependencyErr).RequiredPackages {
+			slices.Sort(dependants)
+			fmt.Printf("The following packages depend on package (%s): %s\n", pkg, strings.Join(dependants, ", "))
+		}
+
+		log.Printf("Error: %s", err)
+		exitCode = 1
+		return
+	} else if err != nil {
+		log.Printf("Error: could not setup operation: %s\n", err)
+		exitCode = 1
+		return
+	}
+
+	// Run operation
+	fmt.Printf("Reverting operation (%d)\n", id)
+	if !runOperation(operation, operationOptions{
+		verb:          "revert",
+		cancelMessage: "Cancelling operation...",
+		verbose:       verbose,
+		force:         force,
+		yesAll:        yesAll,
+	}) {
+		exitCode = 1
+	}
+}
+
+func manageBundle() {
+	// Get flags
+	rootDir, _ := currentFlagSet.GetString("root")
+	verbose, _ := currentFlagSet.GetBool("verbose")
+	force, _ := currentFlagSet.GetBool("force")
+	yesAll, _ := currentFlagSet.GetBool("yes")
+	output, _ := currentFlagSet.GetString("output")
+	signingKey, _ := currentFlagSet.GetString("key")
+	importKeys, _ := currentFlagSet.GetBool("import-keys")
+	skipChecks, _ := currentFlagSet.GetBool("skip-checks")
+	compilationJobs, _ := currentFlagSet.GetInt("jobs")
+
+	switch currentFlagSet.Arg(0) {
+	case "create":
+		if currentFlagSet.NArg() < 2 {
+			log.Printf("Error: usage: bpm bundle create -o <bundle> <packages...>")
+			exitCode = 1
+			return
+		}
+
+		// Check for required permissions
+		if os.Getuid() != 0 {
+			log.Printf("Error: this subcommand needs to be run with superuser permissions")
+			exitCode = 1
+			return
+		}
+
+		// Create BPM Lock file
+		fileLock, err := bpmlib.LockBPM(rootDir)
+		if err != nil {
+			log.Printf("Error: could not create BPM lock file: %s", err)
+			exitCode = 1
+			return
+		}
+		defer fileLock.Unlock()
+
+		// Read local databases
+		err = bpmlib.ReadLocalDatabaseFiles()
+		if err != nil {
+			log.Printf("Error: could not read local databases: %s", err)
+			exitCode = 1
+			return
+		}
+
+		err = bpmlib.CreateBundle(output, currentFlagSet.Args()[1:], signingKey, verbose)
+		if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) {
+			log.Printf("Error: %s", err)
+			exitCode = 1
+			return
+		} else if err != nil {
+			log.Printf("Error: could not create bundle: %s", err)
+			exitCode = 1
+			return
+		}
+		fmt.Printf("Bundle (%s) created successfully!\n", output)
+	case "install":
+		if currentFlagSet.NArg() != 2 {
+			log.Printf("Error: usage: bpm bundle install <bundle>")
+			exitCode = 1
+			return
+		}
+
+		// Check for required permissions
+		if os.Getuid() != 0 {
+			log.Printf("Error: this subcommand needs to be run with superuser permissions")
+			exitCode = 1
+			return
+		}
+
+		// Create BPM Lock file
+		fileLock, err := bpmlib.LockBPM(rootDir)
+		if err != nil {
+			log.Printf("Error: could not create BPM lock file: %s", err)
+			exitCode = 1
+			return
+		}
+		defer fileLock.Unlock()
+
+		// Initialize installed packages map
+		err = bpmlib.InitializeLocalPackageInformation(rootDir)
+		if err != nil {
+			log.Printf("Error: %s", err)
+			exitCode = 1
+			return
+		}
+
+		// Open and verify bundle
+		bundle, err := bpmlib.OpenBundle(currentFlagSet.Arg(1), rootDir, importKeys)
+		if err != nil {
+			log.Printf("Error: could not open bundle: %s", err)
+			exitCode = 1
+			return
+		}
+		defer bundle.Close()
+
+		// Import bundle keys once confirmed
+		if importKeys {
+			fmt.Println("The bundle is signed by one of the following keys:")
+			for _, fingerprint := range bundle.KeyFingerprints {
+				fmt.Println("  " + fingerprint)
+			}
+			if !yesAll && !showConfirmationPrompt("Do you wish to import these keys into the keyring?", false) {
+				fmt.Println("Cancelling bundle installation...")
+				exitCode = 1
+				return
+			}
+
+			err = bundle.ImportKeys(rootDir)
+			if err != nil {
+				log.Printf("Error: could not import bundle keys: %s", err)
+				exitCode = 1
+				return
+			}
+		}
+
+		// Create installation operation
+		operation, err := bundle.InstallPackages(rootDir, force, !skipChecks, verbose)
+		if errors.As(err, &bpmlib.PackageNotFoundErr{}) || errors.As(err, &bpmlib.DependencyNotFoundErr{}) || errors.As(err, &bpmlib.PackageConflictErr{}) || errors.As(err, &bpmlib.PackagesUnavailableOfflineErr{}) {
+			log.Printf("Error: %s", err)
+			exitCode = 1
+			return
+		} else if err != nil {
+			log.Printf("Error: could not setup operation: %s\n", err)
+			exitCode = 1
+			return
+		}
+
+		// Set compilation job count
+		operation.CompilationJobs = compilationJobs
+
+		// Run operation
+		if !runOperation(operation, operationOptions{
+			verb:          "install",
+			cancelMessage: "Cancelling package installation...",
+			verbose:       verbose,
+			force:         force,
+			yesAll:        yesAll,
+		}) {
+			exitCode = 1
+		}
+	default:
+		currentFlagSet.Usage()
+		exitCode = 1
+	}
+}
+
+func manageConfig() {
+	switch currentFlagSet.Arg(0) {
+	case "check":
+		problems, warnings, err := bpmlib.CheckConfig()
+		if err != nil {
+			log.Printf("Error: could not read BPM config: %s", err)
+			exitCode = 1
+			return
+		}
+
+		for _, warning := range warnings {
+			log.Printf("Warning: %s", warning)
+		}
+
+		if len(problems) == 0 {
+			fmt.Println("No problems were found in the BPM configuration")
+			return
+		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) == 1 {
+			log.Printf("Error: 1 problem was found in the BPM configuration")
+		} else {
+			log.Printf("Error: %d problems were found in the BPM configuration", len(problems))
+		}
+		exitCode = 1
+	default:
+		currentFlagSet.Usage()
+		exitCode = 1
+	}
+}
+
 func printUsage() {
 	fmt.Printf("Usage: %s <subcommand> [options]\n", os.Args[0])
 	fmt.Println("Description: Manage system packages")
@@ -1648,6 +2335,8 @@ func printUsage() {
 	fmt.Println("  s, search    Search for packages in remote databases")
 	fmt.Println("  i, install   Install the specified packages")
 	fmt.Println("  r, remove    Remove the specified packages")
+	fmt.Println("  downgrade    Install another cached version of a package")
+	fmt.Println("  undo         Revert the last or specified operation")
 	fmt.Println("  n, cleanup   Remove unused dependencies, files and directories")
 	fmt.Println("  y, sync      Sync all databases")
 	fmt.Println("  u, update    Update installed packages")
@@ -1655,8 +2344,13 @@ func printUsage() {
 	fmt.Println("Developer subcommands:")
 	fmt.Println("  c, compile   Compile source packages and convert them to binary ones")
 	fmt.Println("  p, vercmp    Compare package version numbers")
+	fmt.Println("  repo         Create and manage package repositories")
+	fmt.Println("  bundle       Create and install offline package bundles")
 	fmt.Println("Maintenance subcommands:")
 	fmt.Println("  keyring                   Manage the BPM keyring")
+	fmt.Println("  history                   Show previously performed operations")
+	fmt.Println("  snapshot                  List and restore snapshots taken before operations")
+	fmt.Println("  config                    Check the BPM configuration for problems")
 	fmt.Println("  upgrade-persistent-data   Upgrade persistent data directory to the latest format")
 
 }
@@ -1684,6 +2378,145 @@ func isFlagSet(flagSet *flag.FlagSet, name string) bool {
 	return found
 }
 
+// operationOptions configures how runOperation confirms and executes an operation
+type operationOptions struct {
+	verb               string
+	cancelMessage      string
+	showSourceContents bool
+	downloadOnly       bool
+	verbose            bool
+	force              bool
+	yesAll             bool
+}
+
+// runOperation shows the summary of a prepared operation, asks for confirmation and fetches packages before executing it
+// along with its hooks. It returns whether the operation completed successfully or needed no actions
+func runOperation(operation *bpmlib.BPMOperation, options operationOptions) bool {
+	// Exit if operation contains no actions
+	if len(operation.Actions) == 0 {
+		fmt.Println("No action needs to be taken")
+		return true
+	}
+
+	// Show operation summary
+	operation.ShowOperationSummary()
+
+	// Confirmation Prompt
+	if !options.yesAll {
+		verb := options.verb
+		if options.downloadOnly {
+			verb = "download"
+		}
+		prompt := fmt.Sprintf("Do you wish to %s this package?", verb)
+		if len(operation.Actions) != 1 {
+			prompt = fmt.Sprintf("Do you wish to %s all %d packages?", verb, len(operation.Actions))
+		}
+
+		if !showConfirmationPrompt(prompt, false) {
+			fmt.Println(options.cancelMessage)
+			return false
+		}
+	}
+
+	// Ensure there is enough free disk space
+	if !checkDiskSpace(operation, options.downloadOnly, options.force) {
+		return false
+	}
+
+	// Fetch packages
+	err := operation.FetchPackages(options.verbose)
+	if err != nil {
+		log.Printf("Error: could not fetch packages for operation: %s\n", err)
+		return false
+	}
+
+	// Stop after fetching packages if only downloading
+	if options.downloadOnly {
+		fmt.Println("Packages downloaded successfully!")
+		return true
+	}
+
+	// Get files that will be modifie during this operation
+	operation.GetModifiedFiles()
+
+	if options.showSourceContents {
+		// Show source package contents
+		sourcePackagesShown, err := operation.ShowSourcePackageContent()
+		if err != nil {
+			log.Printf("Error: could not show source package content: %s\n", err)
+			return false
+		}
+
+		// Confirmation Prompt
+		if sourcePackagesShown > 0 && !options.yesAll {
+			if !showConfirmationPrompt("Do you wish to continue?", false) {
+				fmt.Println(options.cancelMessage)
+				return false
+			}
+		}
+	}
+
+	// Get optional dependencies
+	optionalDepends := operation.GetOptionalDependencies()
+
+	// Executing pre-operation hooks
+	fmt.Println("Running pre-operation hooks...")
+	err = operation.RunPreHooks(options.verbose)
+	if err != nil {
+		log.Printf("Error: could not run pre-operation hooks: %s\n", err)
+		return false
+	}
+
+	// Execute operation
+	err = operation.Execute(options.verbose, options.force)
+	if err != nil {
+		log.Printf("Error: could not complete operation: %s\n", err)
+		return false
+	}
+
+	// Executing post-operation hooks
+	fmt.Println("Running post-operation hooks...")
+	err = operation.RunPostHooks(options.verbose)
+	if err != nil {
+		log.Printf("Error: could not run post-operation hooks: %s\n", err)
+		return false
+	}
+
+	fmt.Println("Operation complete!")
+
+	// Show optional dependencies
+	if len(optionalDepends) != 0 {
+		// List optional dependencies
+		fmt.Println("The following optional dependenices have been discovered:")
+		for dependant, depends := range optionalDepends {
+			fmt.Printf("%s: \n", dependant)
+			for _, depend := range depends {
+				fmt.Printf("  - %s\n", depend)
+			}
+		}
+	}
+
+	return true
+}
+
+// checkDiskSpace ensures there is enough free disk space for the operation, only printing a warning if forced. It returns
+// whether the operation may continue
+func checkDiskSpace(operation *bpmlib.BPMOperation, downloadOnly, force bool) bool {
+	err := operation.CheckDiskSpace(downloadOnly)
+	if errors.As(err, &bpmlib.InsufficientDiskSpaceErr{}) {
+		if !force {
+			log.Printf("Error: %s", err)
+			return false
+		}
+		log.Printf("Warning: %s", err)
+	} else if err != nil {
+		log.Printf("Error: could not check free disk space: %s\n", err)
+		return false
+	}
+
+	return true
+}
+
 func showConfirmationPrompt(prompt string, defaultTo bool) bool {
 	reader := bufio.NewReader(os.Stdin)
 	if defaultTo {
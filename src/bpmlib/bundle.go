@@ -0,0 +1,442 @@
+package bpmlib
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const bundleVersion = 1
+
+type bundleInfo struct {
+	BundleVersion int      `yaml:"bundle_version"`
+	CreatedOn     int64    `yaml:"created_on"`
+	Packages      []string `yaml:"packages"`
+}
+
+type Bundle struct {
+	Packages        []string
+	KeyFingerprints []string
+	directory       string
+	database        *BPMDatabase
+}
+
+// CreateBundle writes a bundle containing the given packages and their dependencies along with their signatures, a
+// signed database describing them and the public keys required to verify them
+func CreateBundle(output string, packages []string, signingKey string, verbose bool) error {
+	// Resolve packages and all their dependencies
+	entries, requestedPackages, err := resolveBundleEntries(packages)
+	if err != nil {
+		return err
+	}
+
+	// Fetch packages
+	actions := make([]OperationAction, 0, len(entries))
+	for _, entry := range entries {
+		actions = append(actions, &FetchPackageAction{DatabaseEntry: entry})
+	}
+	fmt.Println("Fetching packages from available databases...")
+	fetchedPackages, err := fetchDatabaseEntries(actions, false, verbose)
+	if err != nil {
+		return err
+	}
+
+	tempDirectory, err := os.MkdirTemp("", "bpm-bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	// Create bundle database and collect bundle files
+	database := &BPMDatabase{
+		DatabaseVersion: databaseVersion,
+		GeneratedOn:     time.Now().Unix(),
+		Entries:         make(map[string]*BPMDatabaseEntry),
+	}
+	bundleFiles := make(map[string]string)
+	repositoryKeys := make([]string, 0)
+	signPackages := make([]string, 0)
+	for _, entry := range entries {
+		bundleFilepath := path.Join("packages", entry.Database.Name, path.Base(entry.Filepath))
+		fetchedPackage := fetchedPackages[entry.Filepath]
+
+		bundleEntry := *entry
+		bundleEntry.Filepath = bundleFilepath
+		database.Entries[entry.Info.Name] = &bundleEntry
+
+		if _, ok := bundleFiles[bundleFilepath]; ok {
+			continue
+		}
+		if verbose {
+			fmt.Printf("Adding package (%s) to bundle as (%s)\n", entry.Info.Name, bundleFilepath)
+		}
+		bundleFiles[bundleFilepath] = fetchedPackage
+
+		// Use package signature from database if it is valid, otherwise sign package using the bundle signing key
+		key, err := getSignatureKey(fetchedPackage, fetchedPackage+".sig", "/var/lib/bpm/gpg")
+		if err != nil {
+			signPackages = append(signPackages, bundleFilepath)
+			continue
+		}
+		bundleFiles[bundleFilepath+".sig"] = fetchedPackage + ".sig"
+		if !slices.Contains(repositoryKeys, key) {
+			repositoryKeys = append(repositoryKeys, key)
+		}
+	}
+
+	for _, bundleFilepath := range signPackages {
+		if verbose {
+			fmt.Printf("Signing package (%s) using bundle signing key\n", bundleFilepath)
+		}
+
+		signature := path.Join(tempDirectory, strings.ReplaceAll(bundleFilepath, "/", "_")+".sig")
+		err := SignFile(bundleFiles[bundleFilepath], signature, signingKey)
+		if err != nil {
+			return fmt.Errorf("could not sign package (%s): %s", bundleFilepath, err)
+		}
+		bundleFiles[bundleFilepath+".sig"] = signature
+	}
+
+	// Write and sign bundle database
+	data, err := yaml.Marshal(database)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(tempDirectory, "database.bpmdb"), data, 0644)
+	if err != nil {
+		return err
+	}
+	err = SignFile(path.Join(tempDirectory, "database.bpmdb"), path.Join(tempDirectory, "database.bpmdb.sig"), signingKey)
+	if err != nil {
+		return fmt.Errorf("could not sign bundle database: %s", err)
+	}
+	bundleFiles["database.bpmdb"] = path.Join(tempDirectory, "database.bpmdb")
+	bundleFiles["database.bpmdb.sig"] = path.Join(tempDirectory, "database.bpmdb.sig")
+
+	// Export public keys of bundle signing key and package signing keys
+	bundleKey, err := getSignatureKey(path.Join(tempDirectory, "database.bpmdb"), path.Join(tempDirectory, "database.bpmdb.sig"), "")
+	if err != nil {
+		return fmt.Errorf("could not get bundle signing key: %s", err)
+	}
+	keys, err := exportKeys("", bundleKey)
+	if err != nil {
+		return fmt.Errorf("could not export bundle signing key: %s", err)
+	}
+	if len(repositoryKeys) > 0 {
+		exportedKeys, err := exportKeys("/var/lib/bpm/gpg", repositoryKeys...)
+		if err != nil {
+			return fmt.Errorf("could not export package signing keys: %s", err)
+		}
+		keys = append(keys, exportedKeys...)
+	}
+	err = os.WriteFile(path.Join(tempDirectory, "keys.asc"), keys, 0644)
+	if err != nil {
+		return err
+	}
+	bundleFiles["keys.asc"] = path.Join(tempDirectory, "keys.asc")
+
+	// Write bundle information
+	data, err = yaml.Marshal(bundleInfo{
+		BundleVersion: bundleVersion,
+		CreatedOn:     database.GeneratedOn,
+		Packages:      requestedPackages,
+	})
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(tempDirectory, "bundle.yml"), data, 0644)
+	if err != nil {
+		return err
+	}
+	bundleFiles["bundle.yml"] = path.Join(tempDirectory, "bundle.yml")
+
+	return writeBundleArchive(output, bundleFiles)
+}
+
+// resolveBundleEntries returns the database entries of the given packages and all of their dependencies, as well as the
+// names of the requested packages
+func resolveBundleEntries(packages []string) ([]*BPMDatabaseEntry, []string, error) {
+	findEntry := func(pkg string) *BPMDatabaseEntry {
+		pkgName, _, _ := SplitPkgNameAndVersion(pkg)
+
+		var entry *BPMDatabaseEntry
+		if e, _, _ := GetDatabaseEntry(pkgName); e != nil {
+			entry = e
+		} else if providers := GetDatabaseVirtualPackageEntry(pkgName); len(providers) > 0 {
+			entry = providers[0]
+		}
+
+		if entry == nil || !EvaluateDependency(pkg, entry.Info.Version) {
+			return nil
+		}
+		return entry
+	}
+
+	entries := make([]*BPMDatabaseEntry, 0)
+	requestedPackages := make([]string, 0)
+	resolved := make(map[string]bool)
+	queue := make([]*BPMDatabaseEntry, 0)
+
+	// Find requested packages
+	pkgsNotFound := make([]string, 0)
+	for _, pkg := range packages {
+		entry := findEntry(pkg)
+		if entry == nil {
+			pkgsNotFound = append(pkgsNotFound, pkg)
+			continue
+		}
+
+		if !slices.Contains(requestedPackages, entry.Info.Name) {
+			requestedPackages = append(requestedPackages, entry.Info.Name)
+		}
+		if !resolved[entry.Info.Name] {
+			resolved[entry.Info.Name] = true
+			queue = append(queue, entry)
+		}
+	}
+	if len(pkgsNotFound) != 0 {
+		return nil, nil, PackageNotFoundErr{pkgsNotFound}
+	}
+
+	// Resolve dependencies breadth-first
+	dependsNotFound := make([]string, 0)
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+		entries = append(entries, entry)
+
+		depends := slices.Concat(entry.Info.Depends, entry.Info.RuntimeDepends)
+		if entry.Info.Type == "source" {
+			depends = slices.Concat(depends, entry.Info.MakeDepends, entry.Info.CheckDepends)
+		}
+
+		for _, depend := range depends {
+			dependEntry := findEntry(depend)
+			if dependEntry == nil {
+				if !slices.Contains(dependsNotFound, depend) {
+					dependsNotFound = append(dependsNotFound, depend)
+				}
+				continue
+			}
+
+			if !resolved[dependEntry.Info.Name] {
+				resolved[dependEntry.Info.Name] = true
+				queue = append(queue, dependEntry)
+			}
+		}
+	}
+	if len(dependsNotFound) != 0 {
+		return nil, nil, DependencyNotFoundErr{dependsNotFound}
+	}
+
+	return entries, requestedPackages, nil
+}
+
+// writeBundleArchive writes the given files into a tar archive, mapping names inside the archive to files on disk
+func writeBundleArchive(output string, files map[string]string) error {
+	file, err := os.CreateTemp(path.Dir(output), ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		err := addFileToTarball(tw, name, files[name])
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	err = file.Chmod(0644)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), output)
+}
+
+func addFileToTarball(tw *tar.Writer, name, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// OpenBundle extracts a bundle into a temporary directory and verifies the signature of its database using the keyring
+// in rootDir. If useBundleKeys is true, the signature is instead verified using only the public keys contained in the
+// bundle, whose fingerprints are stored in the bundle so they can be confirmed before calling ImportKeys
+func OpenBundle(filename, rootDir string, useBundleKeys bool) (bundle *Bundle, err error) {
+	directory, err := os.MkdirTemp("", "bpm-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	bundle = &Bundle{directory: directory}
+	defer func() {
+		// Remove extracted files if bundle could not be opened
+		if err != nil {
+			os.RemoveAll(directory)
+		}
+	}()
+
+	err = extractBundleArchive(filename, directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not extract bundle: %s", err)
+	}
+
+	// Read bundle information
+	data, err := os.ReadFile(path.Join(directory, "bundle.yml"))
+	if err != nil {
+		return nil, err
+	}
+	info := bundleInfo{}
+	err = yaml.Unmarshal(data, &info)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode bundle information: %s", err)
+	}
+	if info.BundleVersion != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version (%d)", info.BundleVersion)
+	}
+	bundle.Packages = info.Packages
+
+	// Verify bundle database
+	dbFile := path.Join(directory, "database.bpmdb")
+	if useBundleKeys {
+		bundle.KeyFingerprints, err = verifyWithKeyFile(dbFile, dbFile+".sig", path.Join(directory, "keys.asc"))
+	} else {
+		err = VerifySignature(dbFile, dbFile+".sig", false, rootDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not verify bundle database signature: %s", err)
+	}
+
+	// Read bundle database
+	data, err = os.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	bundle.database, err = decodeDatabase(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode bundle database: %s", err)
+	}
+	bundle.database.Name = "bundle"
+	bundle.database.VerificationLevel = VerificationLevelAll
+	bundle.database.Source = directory
+	bundle.database.Mirrors = []string{directory}
+	for _, entry := range bundle.database.Entries {
+		entry.Database = bundle.database
+	}
+
+	return bundle, nil
+}
+
+func extractBundleArchive(filename, directory string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Ensure files are extracted inside the bundle directory
+		name := filepath.Clean(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("bundle contains invalid path (%s)", header.Name)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		} else if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("bundle contains unsupported file (%s)", header.Name)
+		}
+
+		err = os.MkdirAll(path.Join(directory, path.Dir(name)), 0755)
+		if err != nil {
+			return err
+		}
+		outFile, err := os.OpenFile(path.Join(directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(outFile, tr)
+		outFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InstallPackages creates an operation installing the packages of the bundle and their dependencies using the bundle as
+// the only available database
+func (bundle *Bundle) InstallPackages(rootDir string, forceInstallation, runChecks, verbose bool) (*BPMOperation, error) {
+	if len(bundle.Packages) == 0 {
+		return nil, errors.New("bundle contains no packages")
+	}
+
+	// Only resolve packages using the bundle database and restore the previous databases afterwards
+	previousDatabases := BPMDatabases
+	BPMDatabases = map[string]*BPMDatabase{bundle.database.Name: bundle.database}
+	defer func() {
+		BPMDatabases = previousDatabases
+	}()
+
+	return InstallPackages(rootDir, InstallationReasonUnknown, false, true, forceInstallation, runChecks, true, verbose, bundle.Packages...)
+}
+
+// ImportKeys imports the public keys contained in the bundle into the keyring in rootDir
+func (bundle *Bundle) ImportKeys(rootDir string) error {
+	return importKeyFile(rootDir, path.Join(bundle.directory, "keys.asc"))
+}
+
+// Close removes the extracted bundle files
+func (bundle *Bundle) Close() error {
+	return os.RemoveAll(bundle.directory)
+}
@@ -0,0 +1,235 @@
+package bpmlib
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// CachedPackage is a package file stored in the fetched or compiled package cache
+type CachedPackage struct {
+	Filepath string
+	Name     string
+	Version  string
+	Size     int64
+}
+
+// GetExpiredCachedPackages returns all cached package files that are not among the newest keepVersions versions of their
+// package. The installed version of a package is always kept and installed packages are skipped if uninstalledOnly is true
+func GetExpiredCachedPackages(rootDir string, keepVersions int, uninstalledOnly bool) ([]CachedPackage, error) {
+	expired := make([]CachedPackage, 0)
+	for _, cacheDir := range []string{"var/cache/bpm/fetched", "var/cache/bpm/compiled"} {
+		cachedPackages, err := readCachedPackages(path.Join(rootDir, cacheDir))
+		if err != nil {
+			return nil, err
+		}
+
+		// Group cached packages by name
+		packagesByName := make(map[string][]CachedPackage)
+		for _, cachedPackage := range cachedPackages {
+			packagesByName[cachedPackage.Name] = append(packagesByName[cachedPackage.Name], cachedPackage)
+		}
+
+		for name, packages := range packagesByName {
+			installedVersion := ""
+			if installedInfo := GetPackageInfo(name, rootDir); installedInfo != nil {
+				if uninstalledOnly {
+					continue
+				}
+				installedVersion = installedInfo.GetFullVersion()
+			}
+
+			// Sort packages from newest to oldest
+			slices.SortFunc(packages, func(a, b CachedPackage) int {
+				if comparison := CompareVersions(b.Version, a.Version); comparison != 0 {
+					return comparison
+				}
+				return strings.Compare(a.Filepath, b.Filepath)
+			})
+
+			// Keep package files belonging to the newest versions
+			versions := 0
+			for i, cachedPackage := range packages {
+				if i == 0 || cachedPackage.Version != packages[i-1].Version {
+					versions++
+				}
+				if versions > keepVersions && cachedPackage.Version != installedVersion {
+					expired = append(expired, cachedPackage)
+				}
+			}
+		}
+	}
+
+	// Sort expired packages
+	slices.SortFunc(expired, func(a, b CachedPackage) int {
+		return strings.Compare(a.Filepath, b.Filepath)
+	})
+
+	return expired, nil
+}
+
+// GetCachedPackageVersions returns every version of a package found in the fetched and compiled package caches sorted
+// from newest to oldest
+func GetCachedPackageVersions(rootDir, pkgName string) ([]CachedPackage, error) {
+	versions := make([]CachedPackage, 0)
+	for _, cacheDir := range []string{"var/cache/bpm/fetched", "var/cache/bpm/compiled"} {
+		cachedPackages, err := readCachedPackages(path.Join(rootDir, cacheDir))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cachedPackage := range cachedPackages {
+			if cachedPackage.Name != pkgName {
+				continue
+			}
+
+			// Skip versions found in a previous cache directory
+			if slices.ContainsFunc(versions, func(v CachedPackage) bool { return v.Version == cachedPackage.Version }) {
+				continue
+			}
+
+			versions = append(versions, cachedPackage)
+		}
+	}
+
+	// Sort versions from newest to oldest
+	slices.SortFunc(versions, func(a, b CachedPackage) int {
+		return CompareVersions(b.Version, a.Version)
+	})
+
+	return versions, nil
+}
+
+// findPackageVersion searches all databases and package caches for the given version of a package, which may be given
+// with or without its revision. It returns either a database entry or the path to a cached package file
+func findPackageVersion(rootDir, pkgName, version string) (*BPMDatabaseEntry, string, error) {
+	matchesVersion := func(pkgInfo *PackageInfo) bool {
+		return version == pkgInfo.GetFullVersion() || version == pkgInfo.Version
+	}
+
+	// Search databases
+	for _, db := range BPMDatabases {
+		if entry, ok := db.Entries[pkgName]; ok && matchesVersion(entry.Info) {
+			return entry, "", nil
+		}
+	}
+
+	// Search package caches
+	cachedPackages, err := GetCachedPackageVersions(rootDir, pkgName)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, cachedPackage := range cachedPackages {
+		revision, ok := strings.CutPrefix(cachedPackage.Version, version+"-")
+		if _, err := strconv.Atoi(revision); version == cachedPackage.Version || (ok && err == nil) {
+			return nil, cachedPackage.Filepath, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// readCachedPackages reads the package information of all package files in the given cache directory
+func readCachedPackages(cacheDir string) ([]CachedPackage, error) {
+	dirEntries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read cache directory (%s): %s", cacheDir, err)
+	}
+
+	cachedPackages := make([]CachedPackage, 0)
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || path.Ext(dirEntry.Name()) != ".bpm" {
+			continue
+		}
+		filepath := path.Join(cacheDir, dirEntry.Name())
+
+		// Read package info
+		raw, err := GetPackageInfoRaw(filepath)
+		if err != nil {
+			log.Printf("Warning: could not read cached package (%s): %s", filepath, err)
+			continue
+		}
+		pkgInfo, err := ReadPackageInfo(raw)
+		if err != nil {
+			log.Printf("Warning: could not read cached package (%s): %s", filepath, err)
+			continue
+		}
+
+		// Get size of package file and its signature
+		var size int64
+		for _, file := range []string{filepath, filepath + ".sig"} {
+			if stat, err := os.Stat(file); err == nil {
+				size += stat.Size()
+			}
+		}
+
+		cachedPackages = append(cachedPackages, CachedPackage{
+			Filepath: filepath,
+			Name:     pkgInfo.Name,
+			Version:  pkgInfo.GetFullVersion(),
+			Size:     size,
+		})
+	}
+
+	return cachedPackages, nil
+}
+
+// RemoveCachedPackages removes the given cached package files along with their signatures
+func RemoveCachedPackages(cachedPackages []CachedPackage, verbose bool) error {
+	for _, cachedPackage := range cachedPackages {
+		for _, file := range []string{cachedPackage.Filepath, cachedPackage.Filepath + ".sig"} {
+			if verbose {
+				if _, err := os.Stat(file); err == nil {
+					log.Printf("Removing file (%s)\n", file)
+				}
+			}
+			err := os.Remove(file)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ShowCachedPackages prints a table of the given cached packages and their total size
+func ShowCachedPackages(cachedPackages []CachedPackage) {
+	if len(cachedPackages) == 0 {
+		fmt.Println("No cached packages would be removed")
+		return
+	}
+
+	var total int64
+	writer := tabwriter.NewWriter(os.Stdout, 6, 4, 6, ' ', 0)
+	fmt.Fprintln(writer, "Package\tVersion\tSize\tFile")
+	for _, cachedPackage := range cachedPackages {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", cachedPackage.Name, cachedPackage.Version, BytesToHumanReadable(cachedPackage.Size), cachedPackage.Filepath)
+		total += cachedPackage.Size
+	}
+	writer.Flush()
+
+	fmt.Printf("%d cached packages would be removed (%s)\n", len(cachedPackages), BytesToHumanReadable(total))
+}
+
+// ApplyCacheRetention removes cached packages according to the cache retention policy in the BPM config if one is set
+func ApplyCacheRetention(rootDir string, verbose bool) error {
+	policy := MainBPMConfig.CacheRetention
+	if policy == nil {
+		return nil
+	}
+
+	expired, err := GetExpiredCachedPackages(rootDir, policy.GetKeepVersions(), policy.UninstalledOnly)
+	if err != nil {
+		return err
+	}
+
+	return RemoveCachedPackages(expired, verbose)
+}
@@ -0,0 +1,70 @@
+package bpmlib
+
+import (
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+func TestGetExpiredCachedPackages(t *testing.T) {
+	rootDir := t.TempDir()
+	cacheDir := path.Join(rootDir, "var/cache/bpm/fetched")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache multiple versions of an installed and an uninstalled package
+	for _, version := range []string{"1.0", "2.0", "3.0", "4.0"} {
+		writeTestPackage(t, cacheDir, "installed", version, nil)
+	}
+	for _, version := range []string{"1.0", "2.0"} {
+		writeTestPackage(t, cacheDir, "uninstalled", version, nil)
+	}
+
+	// Install old version of package
+	if err := installPackage(path.Join(cacheDir, "installed-1.0.bpm"), InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not install package: %s", err)
+	}
+
+	tests := []struct {
+		name            string
+		keepVersions    int
+		uninstalledOnly bool
+		expired         []string
+	}{
+		{name: "keep newest versions", keepVersions: 2, expired: []string{"installed-2.0.bpm"}},
+		{name: "keep newest version", keepVersions: 1, expired: []string{"installed-2.0.bpm", "installed-3.0.bpm", "uninstalled-1.0.bpm"}},
+		{name: "keep no versions", keepVersions: 0, expired: []string{"installed-2.0.bpm", "installed-3.0.bpm", "installed-4.0.bpm", "uninstalled-1.0.bpm", "uninstalled-2.0.bpm"}},
+		{name: "uninstalled packages only", keepVersions: 1, uninstalledOnly: true, expired: []string{"uninstalled-1.0.bpm"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cachedPackages, err := GetExpiredCachedPackages(rootDir, test.keepVersions, test.uninstalledOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Ensure installed versions are never expired
+			expired := make([]string, 0, len(cachedPackages))
+			for _, cachedPackage := range cachedPackages {
+				expired = append(expired, path.Base(cachedPackage.Filepath))
+			}
+			if !slices.Equal(expired, test.expired) {
+				t.Fatalf("expected expired packages %v, got %v", test.expired, expired)
+			}
+		})
+	}
+}
+
+func TestCacheRetentionKeepVersions(t *testing.T) {
+	// Ensure keep_versions defaults to 3 while still allowing 0
+	if keepVersions := (&configCacheRetention{}).GetKeepVersions(); keepVersions != 3 {
+		t.Fatalf("expected keep_versions to default to 3, got %d", keepVersions)
+	}
+	zero := 0
+	if keepVersions := (&configCacheRetention{KeepVersions: &zero}).GetKeepVersions(); keepVersions != 0 {
+		t.Fatalf("expected keep_versions to be 0, got %d", keepVersions)
+	}
+}
@@ -1,6 +1,7 @@
 package bpmlib
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -539,7 +540,11 @@ func downloadPackageFiles(pkgInfo *PackageInfo, tempDirectory string, verbose bo
 				filepath = path.Join(tempDirectory, filepath)
 			}
 
-			err := downloadFile("Downloading file "+path.Base(filepath), downloadUrl, filepath, 0644)
+			client, err := getHTTPClient(getHTTPSettings(nil))
+			if err != nil {
+				return err
+			}
+			err = downloadFile(context.Background(), client, nil, "Downloading file "+path.Base(filepath), downloadUrl, filepath, 0644)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,69 @@
+package bpmlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// databaseFilenames lists the database file variants in the order they are requested from mirrors
+var databaseFilenames = []string{"database.bpmdb.zst", "database.bpmdb.gz", "database.bpmdb"}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressData decompresses zstd or gzip compressed data and returns any other data unchanged
+func decompressData(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, zstdMagic):
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+
+		return decoder.DecodeAll(data, nil)
+	case bytes.HasPrefix(data, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return io.ReadAll(reader)
+	default:
+		return data, nil
+	}
+}
+
+func compressZstd(data []byte) ([]byte, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+
+	return encoder.EncodeAll(data, nil), nil
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -1,24 +1,58 @@
 package bpmlib
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type MainBPMConfigStruct struct {
-	IgnorePackages            []string         `yaml:"ignore_packages"`
-	IgnorePaths               []string         `yaml:"ignore_paths"`
-	ShowSourcePackageContents string           `yaml:"show_source_package_contents"`
-	CleanupMakeDependencies   bool             `yaml:"cleanup_make_dependencies"`
-	Databases                 []configDatabase `yaml:"databases"`
+	IgnorePackages            []string              `yaml:"ignore_packages"`
+	IgnorePaths               []string              `yaml:"ignore_paths"`
+	ShowSourcePackageContents string                `yaml:"show_source_package_contents"`
+	CleanupMakeDependencies   bool                  `yaml:"cleanup_make_dependencies"`
+	ParallelDownloads         int                   `yaml:"parallel_downloads"`
+	ExpiredDatabaseAction     string                `yaml:"expired_database_action"`
+	Offline                   bool                  `yaml:"offline"`
+	CacheRetention            *configCacheRetention `yaml:"cache_retention"`
+	Snapshots                 *configSnapshots      `yaml:"snapshots"`
+	HTTP                      *configHTTP           `yaml:"http"`
+	Databases                 []configDatabase      `yaml:"databases"`
+}
+
+type configCacheRetention struct {
+	KeepVersions    *int `yaml:"keep_versions"`
+	UninstalledOnly bool `yaml:"uninstalled_only"`
+}
+
+// GetKeepVersions returns the number of cached versions to keep for each package, defaulting to 3 if not set
+func (config *configCacheRetention) GetKeepVersions() int {
+	if config.KeepVersions == nil {
+		return 3
+	}
+
+	return *config.KeepVersions
 }
 
 type configDatabase struct {
-	Name              string `yaml:"name"`
-	Source            string `yaml:"source"`
-	VerificationLevel string `yaml:"verification_level"`
-	Disabled          *bool  `yaml:"disabled"`
+	Name              string      `yaml:"name"`
+	Source            string      `yaml:"source"`
+	Mirrors           []string    `yaml:"mirrors"`
+	Mirrorlist        string      `yaml:"mirrorlist"`
+	VerificationLevel string      `yaml:"verification_level"`
+	HTTP              *configHTTP `yaml:"http"`
+	Disabled          *bool       `yaml:"disabled"`
 }
 
 type CompilationBPMConfigStruct struct {
@@ -30,44 +64,461 @@ type CompilationBPMConfigStruct struct {
 var MainBPMConfig MainBPMConfigStruct
 var CompilationBPMConfig CompilationBPMConfigStruct
 
+const mainBPMConfigFile = "/etc/bpm.conf"
+const compilationBPMConfigFile = "/etc/bpm-compilation.conf"
+
 func ReadConfig() (err error) {
-	var file *os.File
+	mainConfig, compilationConfig, problems, _, err := readConfigFiles()
+	if err != nil {
+		return err
+	}
+	if len(problems) != 0 {
+		return ConfigValidationErr{Problems: problems}
+	}
+
+	MainBPMConfig = *mainConfig
+	CompilationBPMConfig = *compilationConfig
+
+	// Remove disabled databases from memory
+	for i := len(MainBPMConfig.Databases) - 1; i >= 0; i-- {
+		if MainBPMConfig.Databases[i].Disabled != nil && *MainBPMConfig.Databases[i].Disabled {
+			MainBPMConfig.Databases = append(MainBPMConfig.Databases[:i], MainBPMConfig.Databases[i+1:]...)
+		}
+	}
+
+	return nil
+}
+
+// CheckConfig reads all BPM config files and returns every problem found in them, along with warnings about options
+// that depend on the current host, such as commands that cannot be found or files that cannot be read
+func CheckConfig() (problems []ConfigError, warnings []ConfigError, err error) {
+	_, _, problems, warnings, err = readConfigFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return problems, warnings, nil
+}
 
+func readConfigFiles() (mainConfig *MainBPMConfigStruct, compilationConfig *CompilationBPMConfigStruct, problems []ConfigError, warnings []ConfigError, err error) {
 	// Set default config options
-	MainBPMConfig = MainBPMConfigStruct{
+	mainConfig = &MainBPMConfigStruct{
 		ShowSourcePackageContents: "always",
 		CleanupMakeDependencies:   true,
+		ParallelDownloads:         5,
+		ExpiredDatabaseAction:     "warn",
 	}
+	compilationConfig = &CompilationBPMConfigStruct{}
 
 	// Read main BPM config
-	file, err = os.Open("/etc/bpm.conf")
+	node, decodeProblems, err := readConfigFile(mainBPMConfigFile, mainConfig)
 	if err != nil {
-		return err
+		return nil, nil, nil, nil, err
 	}
-	err = yaml.NewDecoder(file).Decode(&MainBPMConfig)
-	if err != nil {
-		return err
-	}
-	file.Close()
+	problems = append(problems, decodeProblems...)
+	problems = append(problems, mainConfig.validate(mainBPMConfigFile, node)...)
+	sortConfigErrors(problems, mainBPMConfigFile)
+	warnings = append(warnings, mainConfig.checkHost(mainBPMConfigFile, node)...)
+	sortConfigErrors(warnings, mainBPMConfigFile)
 
 	// Read compilation BPM config
-	if _, err := os.Stat("/etc/bpm-compilation.conf"); err == nil {
-		file, err = os.Open("/etc/bpm-compilation.conf")
+	if _, err := os.Stat(compilationBPMConfigFile); err == nil {
+		node, decodeProblems, err := readConfigFile(compilationBPMConfigFile, compilationConfig)
 		if err != nil {
-			return err
+			return nil, nil, nil, nil, err
+		}
+		decodeProblems = append(decodeProblems, compilationConfig.validate(compilationBPMConfigFile, node)...)
+		sortConfigErrors(decodeProblems, compilationBPMConfigFile)
+		problems = append(problems, decodeProblems...)
+		warnings = append(warnings, compilationConfig.checkHost(compilationBPMConfigFile, node)...)
+	}
+
+	return mainConfig, compilationConfig, problems, warnings, nil
+}
+
+// sortConfigErrors sorts problems by line, listing problems in the given config file before those in files it references
+func sortConfigErrors(problems []ConfigError, filename string) {
+	slices.SortStableFunc(problems, func(a, b ConfigError) int {
+		if a.Filename != b.Filename {
+			if a.Filename == filename {
+				return -1
+			} else if b.Filename == filename {
+				return 1
+			}
+			return strings.Compare(a.Filename, b.Filename)
+		}
+		return a.Line - b.Line
+	})
+}
+
+// readConfigFile strictly decodes the given config file into out and returns the parsed yaml document for locating options
+func readConfigFile(filename string, out any) (*yaml.Node, []ConfigError, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Parse document to find the location of options later on
+	node := &yaml.Node{}
+	err = yaml.Unmarshal(data, node)
+	if err != nil {
+		line, message := splitYamlErrorLine(err.Error())
+		return nil, []ConfigError{{Filename: filename, Line: line, Message: message}}, nil
+	}
+
+	// Decode config and reject unknown options
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(out)
+	if err == io.EOF {
+		return node, nil, nil
+	}
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		problems := make([]ConfigError, 0, len(typeErr.Errors))
+		for _, e := range typeErr.Errors {
+			line, message := splitYamlErrorLine(e)
+			problems = append(problems, ConfigError{Filename: filename, Line: line, Message: message})
+		}
+		return node, problems, nil
+	} else if err != nil {
+		line, message := splitYamlErrorLine(err.Error())
+		return node, []ConfigError{{Filename: filename, Line: line, Message: message}}, nil
+	}
+
+	return node, nil, nil
+}
+
+var (
+	yamlErrorLineRegex    = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownFieldRegex = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// splitYamlErrorLine separates the line number from a yaml error message and rewrites its message to be more readable
+func splitYamlErrorLine(message string) (int, string) {
+	line := 0
+	if match := yamlErrorLineRegex.FindStringSubmatch(message); match != nil {
+		line, _ = strconv.Atoi(match[1])
+		message = match[2]
+	}
+
+	if match := yamlUnknownFieldRegex.FindStringSubmatch(message); match != nil {
+		message = fmt.Sprintf("unknown option (%s)", match[1])
+	}
+
+	return line, strings.TrimPrefix(message, "yaml: ")
+}
+
+// findConfigNodeLine returns the line of the value at the given path of keys and indexes, or the line of its closest parent
+func findConfigNodeLine(node *yaml.Node, path ...any) int {
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, elem := range path {
+		var next *yaml.Node
+		switch elem := elem.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				break
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == elem {
+					next = node.Content[i+1]
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && elem < len(node.Content) {
+				next = node.Content[elem]
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	return node.Line
+}
+
+func (config *MainBPMConfigStruct) validate(filename string, node *yaml.Node) (problems []ConfigError) {
+	addProblem := func(message string, path ...any) {
+		problems = append(problems, ConfigError{Filename: filename, Line: findConfigNodeLine(node, path...), Message: message})
+	}
+
+	// Ensure ignored packages have valid names
+	for i, pkg := range config.IgnorePackages {
+		if match, _ := regexp.MatchString("^[a-zA-Z0-9._-]+$", pkg); !match {
+			addProblem(fmt.Sprintf("ignored package name (%s) is invalid", pkg), "ignore_packages", i)
+		}
+	}
+
+	// Ensure ignored paths are valid patterns
+	for i, ignoredPath := range config.IgnorePaths {
+		if _, err := filepath.Match(ignoredPath, ""); err != nil {
+			addProblem(fmt.Sprintf("ignored path (%s) is not a valid pattern", ignoredPath), "ignore_paths", i)
+		} else if strings.HasPrefix(ignoredPath, "/") {
+			addProblem(fmt.Sprintf("ignored path (%s) must not start with a slash", ignoredPath), "ignore_paths", i)
+		}
+	}
+
+	// Ensure source package contents option is valid
+	switch config.ShowSourcePackageContents {
+	case "always", "install-only", "never":
+	default:
+		addProblem(fmt.Sprintf("show_source_package_contents must be one of 'always', 'install-only' or 'never', not '%s'", config.ShowSourcePackageContents), "show_source_package_contents")
+	}
+
+	// Ensure parallel download count is valid
+	if config.ParallelDownloads < 1 {
+		addProblem(fmt.Sprintf("parallel_downloads must be at least 1, not %d", config.ParallelDownloads), "parallel_downloads")
+	}
+
+	// Ensure expired database action is valid
+	switch config.ExpiredDatabaseAction {
+	case "warn", "refuse":
+	default:
+		addProblem(fmt.Sprintf("expired_database_action must be one of 'warn' or 'refuse', not '%s'", config.ExpiredDatabaseAction), "expired_database_action")
+	}
+
+	// Ensure cache retention policy is valid
+	if config.CacheRetention != nil && config.CacheRetention.GetKeepVersions() < 0 {
+		addProblem(fmt.Sprintf("cache_retention keep_versions must not be negative, not %d", config.CacheRetention.GetKeepVersions()), "cache_retention", "keep_versions")
+	}
+
+	// Ensure snapshot settings are valid
+	if config.Snapshots != nil {
+		if _, ok := snapshotBackends[config.Snapshots.Backend]; !ok && config.Snapshots.Backend != "" {
+			addProblem(fmt.Sprintf("snapshots backend (%s) is not a known snapshot backend", config.Snapshots.Backend), "snapshots", "backend")
+		}
+		if config.Snapshots.Backend == "command" && config.Snapshots.CreateCommand == "" {
+			addProblem("snapshots create_command must be set when using the command backend", "snapshots")
+		}
+		if config.Snapshots.Backend == "command" && config.Snapshots.RestoreCommand == "" {
+			addProblem("snapshots restore_command must be set when using the command backend", "snapshots")
+		}
+		if config.Snapshots.Directory != "" && !filepath.IsAbs(config.Snapshots.Directory) {
+			addProblem(fmt.Sprintf("snapshots directory (%s) must be an absolute path", config.Snapshots.Directory), "snapshots", "directory")
+		}
+		if config.Snapshots.Keep < 0 {
+			addProblem(fmt.Sprintf("snapshots keep must not be negative, not %d", config.Snapshots.Keep), "snapshots", "keep")
+		}
+	}
+
+	// Ensure HTTP settings are valid
+	if config.HTTP != nil {
+		problems = append(problems, config.HTTP.validate("", filename, node, "http")...)
+	}
+
+	// Ensure databases are valid
+	databaseNames := make(map[string]int)
+	for i, db := range config.Databases {
+		if db.Name == "" {
+			addProblem("database has no name", "databases", i)
+		} else if match, _ := regexp.MatchString("^[a-zA-Z0-9._-]+$", db.Name); !match {
+			addProblem(fmt.Sprintf("database name (%s) is invalid", db.Name), "databases", i, "name")
+		} else if first, ok := databaseNames[db.Name]; ok {
+			addProblem(fmt.Sprintf("database name (%s) is already used on line %d", db.Name, findConfigNodeLine(node, "databases", first, "name")), "databases", i, "name")
+		} else {
+			databaseNames[db.Name] = i
+		}
+
+		if db.Source == "" && len(db.Mirrors) == 0 && db.Mirrorlist == "" {
+			addProblem(fmt.Sprintf("database (%s) has no source, mirrors or mirrorlist", db.Name), "databases", i)
+		} else if db.Source != "" {
+			if err := validateDatabaseSource(db.Source); err != nil {
+				addProblem(fmt.Sprintf("database (%s) has an invalid source: %s", db.Name, err), "databases", i, "source")
+			}
+		}
+
+		for j, mirror := range db.Mirrors {
+			if err := validateDatabaseSource(mirror); err != nil {
+				addProblem(fmt.Sprintf("database (%s) has an invalid mirror: %s", db.Name, err), "databases", i, "mirrors", j)
+			}
+		}
+
+		if db.Mirrorlist != "" && !strings.HasPrefix(db.Mirrorlist, "/") {
+			addProblem(fmt.Sprintf("database (%s) mirrorlist (%s) must be an absolute path", db.Name, db.Mirrorlist), "databases", i, "mirrorlist")
+		}
+
+		if _, err := parseVerificationLevel(db.VerificationLevel); err != nil {
+			addProblem(fmt.Sprintf("database (%s) has an invalid verification level: %s", db.Name, err), "databases", i, "verification_level")
+		}
+
+		if db.HTTP != nil {
+			problems = append(problems, db.HTTP.validate(db.Name, filename, node, "databases", i, "http")...)
 		}
-		err = yaml.NewDecoder(file).Decode(&CompilationBPMConfig)
+	}
+
+	return problems
+}
+
+func (config *CompilationBPMConfigStruct) validate(filename string, node *yaml.Node) (problems []ConfigError) {
+	addProblem := func(message string, path ...any) {
+		problems = append(problems, ConfigError{Filename: filename, Line: findConfigNodeLine(node, path...), Message: message})
+	}
+
+	// Ensure compilation job count is valid
+	if config.CompilationJobs < 0 {
+		addProblem(fmt.Sprintf("compilation_jobs must not be negative, not %d", config.CompilationJobs), "compilation_jobs")
+	}
+
+	// Ensure compilation environment variables are valid
+	for i, variable := range config.CompilationEnvironment {
+		if match, _ := regexp.MatchString("^[a-zA-Z_][a-zA-Z0-9_]*=", variable); !match {
+			addProblem(fmt.Sprintf("compilation environment variable (%s) must be in the form of NAME=value", variable), "compilation_env", i)
+		}
+	}
+
+	return problems
+}
+
+// checkHost returns warnings about compilation options which cannot be used on the current host
+func (config *CompilationBPMConfigStruct) checkHost(filename string, node *yaml.Node) (warnings []ConfigError) {
+	line := findConfigNodeLine(node, "privilege_escalator_cmd")
+
+	// Ensure privilege escalator command can be found
+	if config.PrivilegeEscalatorCmd == "" {
+		warnings = append(warnings, ConfigError{Filename: filename, Line: line, Message: "privilege_escalator_cmd is empty, packages cannot be compiled by non-root users"})
+	} else if _, err := exec.LookPath(config.PrivilegeEscalatorCmd); err != nil {
+		warnings = append(warnings, ConfigError{Filename: filename, Line: line, Message: fmt.Sprintf("privilege_escalator_cmd (%s) could not be found in PATH", config.PrivilegeEscalatorCmd)})
+	}
+
+	return warnings
+}
+
+// validate returns all problems found in an HTTP config section, prefixing messages with the database name if it is not empty
+func (config *configHTTP) validate(dbName, filename string, node *yaml.Node, path ...any) (problems []ConfigError) {
+	addProblem := func(message string, option string) {
+		if dbName != "" {
+			message = fmt.Sprintf("database (%s) %s", dbName, message)
+		}
+		problems = append(problems, ConfigError{Filename: filename, Line: findConfigNodeLine(node, append(path, option)...), Message: message})
+	}
+
+	// Ensure proxy is a valid URL
+	if config.Proxy != "" {
+		u, err := url.Parse(config.Proxy)
 		if err != nil {
-			return err
+			addProblem(fmt.Sprintf("http proxy is invalid: %s", err), "proxy")
+		} else if !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) {
+			addProblem(fmt.Sprintf("http proxy has an unsupported URL scheme (%s)", u.Scheme), "proxy")
+		} else if u.Host == "" {
+			addProblem("http proxy URL contains no host", "proxy")
 		}
-		file.Close()
 	}
 
-	// Remove disabled databases from memory
-	for i := len(MainBPMConfig.Databases) - 1; i >= 0; i-- {
-		if MainBPMConfig.Databases[i].Disabled != nil && *MainBPMConfig.Databases[i].Disabled {
-			MainBPMConfig.Databases = append(MainBPMConfig.Databases[:i], MainBPMConfig.Databases[i+1:]...)
+	// Ensure numeric options are not negative
+	for option, value := range map[string]*int{
+		"connect_timeout": config.ConnectTimeout,
+		"read_timeout":    config.ReadTimeout,
+		"retries":         config.Retries,
+		"retry_backoff":   config.RetryBackoff,
+	} {
+		if value != nil && *value < 0 {
+			addProblem(fmt.Sprintf("http %s must not be negative, not %d", option, *value), option)
+		}
+	}
+
+	// Ensure file paths are absolute. Files are only read once an HTTP client is created, since they may not be
+	// readable by the current user
+	for option, value := range map[string]string{
+		"ca_bundle":          config.CABundle,
+		"client_certificate": config.ClientCertificate,
+		"client_key":         config.ClientKey,
+		"credentials_file":   config.CredentialsFile,
+	} {
+		if value != "" && !strings.HasPrefix(value, "/") {
+			addProblem(fmt.Sprintf("http %s (%s) must be an absolute path", strings.ReplaceAll(option, "_", " "), value), option)
+		}
+	}
+
+	// Ensure client certificate and key are given together
+	if config.ClientCertificate != "" && config.ClientKey == "" {
+		addProblem("http client certificate requires a client key", "client_certificate")
+	} else if config.ClientCertificate == "" && config.ClientKey != "" {
+		addProblem("http client key requires a client certificate", "client_key")
+	}
+
+	slices.SortStableFunc(problems, func(a, b ConfigError) int {
+		return a.Line - b.Line
+	})
+
+	return problems
+}
+
+// checkHost returns warnings about options which cannot be used on the current host
+func (config *MainBPMConfigStruct) checkHost(filename string, node *yaml.Node) (warnings []ConfigError) {
+	for i, db := range config.Databases {
+		if db.Mirrorlist != "" && strings.HasPrefix(db.Mirrorlist, "/") {
+			warnings = append(warnings, checkMirrorlist(db.Name, db.Mirrorlist, filename, findConfigNodeLine(node, "databases", i, "mirrorlist"))...)
+		}
+	}
+
+	return warnings
+}
+
+// checkMirrorlist returns warnings about a mirrorlist file which cannot be read or contains invalid mirrors
+func checkMirrorlist(dbName, mirrorlist, filename string, line int) (warnings []ConfigError) {
+	data, err := os.ReadFile(mirrorlist)
+	if err != nil {
+		return []ConfigError{{Filename: filename, Line: line, Message: fmt.Sprintf("database (%s) mirrorlist could not be read: %s", dbName, err)}}
+	}
+
+	mirrorCount := 0
+	lineNumber := 0
+	for mirror := range strings.Lines(string(data)) {
+		lineNumber++
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" || strings.HasPrefix(mirror, "#") {
+			continue
+		}
+
+		mirrorCount++
+		if err := validateDatabaseSource(mirror); err != nil {
+			warnings = append(warnings, ConfigError{Filename: mirrorlist, Line: lineNumber, Message: fmt.Sprintf("invalid mirror: %s", err)})
+		}
+	}
+	if mirrorCount == 0 {
+		warnings = append(warnings, ConfigError{Filename: filename, Line: line, Message: fmt.Sprintf("database (%s) mirrorlist (%s) contains no mirrors", dbName, mirrorlist)})
+	}
+
+	return warnings
+}
+
+func validateDatabaseSource(source string) error {
+	if source == "" {
+		return errors.New("no source specified")
+	}
+
+	// Allow absolute paths to local directories
+	if strings.HasPrefix(source, "/") {
+		return nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return errors.New("URL contains no host")
+		}
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return fmt.Errorf("file URL must not contain a remote host (%s)", u.Host)
+		}
+		if !strings.HasPrefix(u.Path, "/") {
+			return errors.New("file URL must contain an absolute path")
 		}
+	default:
+		return fmt.Errorf("unsupported URL scheme (%s)", u.Scheme)
 	}
 
 	return nil
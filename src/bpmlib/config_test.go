@@ -0,0 +1,122 @@
+package bpmlib
+
+import (
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+// checkTestConfig writes the given main config to a temporary file and returns the problems and warnings found in it
+func checkTestConfig(t *testing.T, content string) (problems []ConfigError, warnings []ConfigError) {
+	t.Helper()
+
+	filename := path.Join(t.TempDir(), "bpm.conf")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &MainBPMConfigStruct{
+		ShowSourcePackageContents: "always",
+		ParallelDownloads:         5,
+		ExpiredDatabaseAction:     "warn",
+	}
+	node, problems, err := readConfigFile(filename, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = append(problems, config.validate(filename, node)...)
+
+	return problems, config.checkHost(filename, node)
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		line     int
+		message  string
+		warnings int
+	}{
+		{
+			name:    "valid",
+			content: "parallel_downloads: 3\ndatabases:\n  - name: main\n    source: https://example.com/\n",
+		},
+		{
+			name:    "unknown option",
+			content: "parallel_downloads: 3\nunknown_option: true\n",
+			line:    2,
+			message: "unknown option (unknown_option)",
+		},
+		{
+			name:    "invalid type",
+			content: "parallel_downloads: many\n",
+			line:    1,
+			message: "cannot unmarshal !!str `many` into int",
+		},
+		{
+			name:    "invalid value",
+			content: "expired_database_action: ignore\n",
+			line:    1,
+			message: "expired_database_action must be one of 'warn' or 'refuse', not 'ignore'",
+		},
+		{
+			name:    "duplicate database name",
+			content: "databases:\n  - name: main\n    source: https://example.com/\n  - name: main\n    source: https://example.org/\n",
+			line:    4,
+			message: "database name (main) is already used on line 2",
+		},
+		{
+			name:    "relative mirrorlist",
+			content: "databases:\n  - name: main\n    mirrorlist: mirrors.txt\n",
+			line:    3,
+			message: "database (main) mirrorlist (mirrors.txt) must be an absolute path",
+		},
+		{
+			name:     "unreadable mirrorlist",
+			content:  "databases:\n  - name: main\n    mirrorlist: /nonexistent/mirrors.txt\n",
+			warnings: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, warnings := checkTestConfig(t, test.content)
+
+			if test.message == "" && len(problems) != 0 {
+				t.Fatalf("expected no problems, got %v", problems)
+			} else if test.message != "" && !slices.ContainsFunc(problems, func(problem ConfigError) bool {
+				return problem.Line == test.line && problem.Message == test.message
+			}) {
+				t.Fatalf("expected problem %q on line %d, got %v", test.message, test.line, problems)
+			}
+
+			if len(warnings) != test.warnings {
+				t.Fatalf("expected %d warnings, got %v", test.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestCompilationConfigHostChecks(t *testing.T) {
+	filename := path.Join(t.TempDir(), "bpm-compilation.conf")
+	if err := os.WriteFile(filename, []byte("privilege_escalator_cmd: nonexistent-command\ncompilation_jobs: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &CompilationBPMConfigStruct{}
+	node, problems, err := readConfigFile(filename, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = append(problems, config.validate(filename, node)...)
+
+	// Ensure missing commands are only reported as warnings
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+	warnings := config.checkHost(filename, node)
+	if len(warnings) != 1 || warnings[0].Line != 1 {
+		t.Fatalf("expected a warning on line 1, got %v", warnings)
+	}
+}
@@ -0,0 +1,155 @@
+package bpmlib
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+)
+
+// databaseCacheVersion must be increased whenever the layout of the cache changes
+const databaseCacheVersion = 1
+
+const databaseCacheDir = "/var/cache/bpm/databases/"
+
+type databaseCache struct {
+	CacheVersion    int
+	SourceSha256    string
+	DatabaseVersion int
+	GeneratedOn     int64
+	ValidUntil      int64
+	Entries         map[string]*databaseCacheEntry
+	VirtualPackages map[string][]string
+}
+
+type databaseCacheEntry struct {
+	Info          *PackageInfo
+	Filepath      string
+	DownloadSize  int64
+	InstalledSize int64
+	Sha256        string
+}
+
+// readDatabaseCache reads the binary cache of a database if it was generated from a database file with the given checksum
+func readDatabaseCache(dbName, sourceSha256 string) (*BPMDatabase, error) {
+	file, err := os.Open(path.Join(databaseCacheDir, dbName+".cache"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cache := &databaseCache{}
+	err = gob.NewDecoder(file).Decode(cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache.CacheVersion != databaseCacheVersion || cache.SourceSha256 != sourceSha256 {
+		return nil, errors.New("database cache is outdated")
+	}
+
+	database := &BPMDatabase{
+		DatabaseVersion: cache.DatabaseVersion,
+		GeneratedOn:     cache.GeneratedOn,
+		ValidUntil:      cache.ValidUntil,
+		Entries:         make(map[string]*BPMDatabaseEntry, len(cache.Entries)),
+		VirtualPackages: make(map[string][]*BPMDatabaseEntry, len(cache.VirtualPackages)),
+	}
+	for name, entry := range cache.Entries {
+		database.Entries[name] = &BPMDatabaseEntry{
+			Info:          entry.Info,
+			Filepath:      entry.Filepath,
+			DownloadSize:  entry.DownloadSize,
+			InstalledSize: entry.InstalledSize,
+			Sha256:        entry.Sha256,
+		}
+	}
+	for vpkg, providers := range cache.VirtualPackages {
+		for _, provider := range providers {
+			if entry, ok := database.Entries[provider]; ok {
+				database.VirtualPackages[vpkg] = append(database.VirtualPackages[vpkg], entry)
+			}
+		}
+	}
+
+	return database, nil
+}
+
+// updateDatabaseCache writes a binary cache of the given database file data unless an up-to-date cache already exists
+func updateDatabaseCache(dbName string, data []byte) error {
+	checksum := sha256.Sum256(data)
+	sourceSha256 := hex.EncodeToString(checksum[:])
+	if _, err := readDatabaseCache(dbName, sourceSha256); err == nil {
+		return nil
+	}
+
+	database, err := decodeDatabase(data)
+	if err != nil {
+		return err
+	}
+
+	return writeDatabaseCache(dbName, sourceSha256, database)
+}
+
+// writeDatabaseCache writes a binary cache of a decoded database so it can be loaded without decoding the database file again
+func writeDatabaseCache(dbName, sourceSha256 string, database *BPMDatabase) error {
+	cache := &databaseCache{
+		CacheVersion:    databaseCacheVersion,
+		SourceSha256:    sourceSha256,
+		DatabaseVersion: database.DatabaseVersion,
+		GeneratedOn:     database.GeneratedOn,
+		ValidUntil:      database.ValidUntil,
+		Entries:         make(map[string]*databaseCacheEntry, len(database.Entries)),
+		VirtualPackages: make(map[string][]string, len(database.VirtualPackages)),
+	}
+	entryNames := make(map[*BPMDatabaseEntry]string, len(database.Entries))
+	for name, entry := range database.Entries {
+		cache.Entries[name] = &databaseCacheEntry{
+			Info:          entry.Info,
+			Filepath:      entry.Filepath,
+			DownloadSize:  entry.DownloadSize,
+			InstalledSize: entry.InstalledSize,
+			Sha256:        entry.Sha256,
+		}
+		entryNames[entry] = name
+	}
+
+	// Store virtual package providers by entry name
+	for vpkg, providers := range database.VirtualPackages {
+		for _, provider := range providers {
+			if name, ok := entryNames[provider]; ok {
+				cache.VirtualPackages[vpkg] = append(cache.VirtualPackages[vpkg], name)
+			}
+		}
+	}
+
+	// Create cache directory
+	err := os.MkdirAll(databaseCacheDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Encode cache into temporary file and move it into place
+	file, err := os.CreateTemp(databaseCacheDir, "."+dbName+".cache.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = gob.NewEncoder(file).Encode(cache)
+	if err != nil {
+		return err
+	}
+	err = file.Chmod(0644)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path.Join(databaseCacheDir, dbName+".cache"))
+}
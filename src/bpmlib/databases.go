@@ -2,17 +2,21 @@ package bpmlib
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"maps"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -26,12 +30,23 @@ const (
 )
 
 type BPMDatabase struct {
-	DatabaseVersion   int                          `yaml:"database_version"`
-	Entries           map[string]*BPMDatabaseEntry `yaml:"entries"`
-	VirtualPackages   map[string][]*BPMDatabaseEntry
-	Name              string
-	VerificationLevel VerificationLevel
-	Source            string
+	DatabaseVersion   int                            `yaml:"database_version"`
+	GeneratedOn       int64                          `yaml:"generated_on"`
+	ValidUntil        int64                          `yaml:"valid_until,omitempty"`
+	Entries           map[string]*BPMDatabaseEntry   `yaml:"entries"`
+	VirtualPackages   map[string][]*BPMDatabaseEntry `yaml:"-"`
+	Name              string                         `yaml:"-"`
+	VerificationLevel VerificationLevel              `yaml:"-"`
+	Mirrors           []string                       `yaml:"-"`
+	Source            string                         `yaml:"-"`
+	httpSettings      httpSettings
+}
+
+type databaseValidators struct {
+	Mirror       string `yaml:"mirror"`
+	Filename     string `yaml:"filename"`
+	ETag         string `yaml:"etag,omitempty"`
+	LastModified string `yaml:"last_modified,omitempty"`
 }
 
 type BPMDatabaseEntry struct {
@@ -39,52 +54,142 @@ type BPMDatabaseEntry struct {
 	Filepath      string       `yaml:"filepath"`
 	DownloadSize  int64        `yaml:"download_size"`
 	InstalledSize int64        `yaml:"installed_size"`
-	Database      *BPMDatabase
+	Sha256        string       `yaml:"sha256"`
+	Database      *BPMDatabase `yaml:"-"`
 }
 
 var BPMDatabases = make(map[string]*BPMDatabase)
 
+func parseVerificationLevel(level string) (VerificationLevel, error) {
+	switch level {
+	case "0", "none":
+		return VerificationLevelNone, nil
+	case "", "1", "all":
+		return VerificationLevelAll, nil
+	case "2", "trusted":
+		return VerificationLevelTrusted, nil
+	default:
+		return VerificationLevelAll, fmt.Errorf("unknown verification level (%s)", level)
+	}
+}
+
 func (db *BPMDatabase) ContainsPackage(pkg string) bool {
 	_, ok := db.Entries[pkg]
 	return ok
 }
 
+func readDatabaseFile(dbFile string) (*BPMDatabase, error) {
+	data, err := os.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decompress database if it is stored compressed
+	data, err = decompressData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal yaml
+	database := &BPMDatabase{}
+	err = yaml.Unmarshal(data, database)
+	if err != nil {
+		return nil, err
+	}
+
+	return database, nil
+}
+
+// IsExpired returns whether the database is past the expiry time set by its repository
+func (db *BPMDatabase) IsExpired() bool {
+	return db.ValidUntil != 0 && time.Now().Unix() > db.ValidUntil
+}
+
+// checkDatabaseExpiry warns about or refuses expired databases depending on the expired_database_action option
+func checkDatabaseExpiry() error {
+	expired := make([]string, 0)
+	for _, db := range BPMDatabases {
+		if db.IsExpired() {
+			expired = append(expired, db.Name)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	err := DatabaseExpiredErr{databases: expired}
+	if MainBPMConfig.ExpiredDatabaseAction == "refuse" {
+		return err
+	}
+	log.Printf("Warning: %s", err)
+
+	return nil
+}
+
 func (db *configDatabase) ReadLocalDatabase() error {
 	dbFile := "/var/lib/bpm/databases/" + db.Name + ".bpmdb"
 	if _, err := os.Stat(dbFile); err != nil {
 		return nil
 	}
 
-	bytes, err := os.ReadFile(dbFile)
+	data, err := os.ReadFile(dbFile)
 	if err != nil {
 		return err
 	}
+	checksum := sha256.Sum256(data)
 
-	// Unmarshal yaml
-	database := &BPMDatabase{}
-	err = yaml.Unmarshal(bytes, database)
+	// Load database from binary cache, falling back to decoding the database file. The cache is only written while
+	// syncing databases, since its directory is not writable by other users
+	database, err := readDatabaseCache(db.Name, hex.EncodeToString(checksum[:]))
 	if err != nil {
-		return err
+		database, err = decodeDatabase(data)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Initialize struct values
-	database.VirtualPackages = make(map[string][]*BPMDatabaseEntry)
 	database.Name = db.Name
-	switch db.VerificationLevel {
-	case "0", "none":
-		database.VerificationLevel = VerificationLevelNone
-	case "1", "all":
-		database.VerificationLevel = VerificationLevelAll
-	case "2", "trusted":
-		database.VerificationLevel = VerificationLevelTrusted
-	default:
-		database.VerificationLevel = VerificationLevelAll
+	database.VerificationLevel, err = parseVerificationLevel(db.VerificationLevel)
+	if err != nil {
+		return err
 	}
-	database.Source = db.Source
-
-	for entryName, entry := range database.Entries {
+	database.Mirrors, err = db.getMirrors()
+	if err != nil {
+		return err
+	}
+	if len(database.Mirrors) > 0 {
+		database.Source = database.Mirrors[0]
+	}
+	database.httpSettings = getHTTPSettings(db)
+	for _, entry := range database.Entries {
 		entry.Database = database
+	}
 
+	BPMDatabases[db.Name] = database
+
+	return nil
+}
+
+// decodeDatabase decodes database file data and creates entries for split and virtual packages
+func decodeDatabase(data []byte) (*BPMDatabase, error) {
+	// Decompress database if it is stored compressed
+	data, err := decompressData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal yaml
+	database := &BPMDatabase{}
+	err = yaml.Unmarshal(data, database)
+	if err != nil {
+		return nil, err
+	}
+	database.VirtualPackages = make(map[string][]*BPMDatabaseEntry)
+
+	// Loop over existing entries only as split package entries are added while looping
+	for _, entryName := range slices.Collect(maps.Keys(database.Entries)) {
+		entry := database.Entries[entryName]
 		if entry.Info.IsSplitPackage() {
 			delete(database.Entries, entryName)
 
@@ -93,7 +198,7 @@ func (db *configDatabase) ReadLocalDatabase() error {
 				// Turn split package into json data
 				splitPkgJson, err := yaml.Marshal(splitPkg)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				// Clone all main package fields onto split package
@@ -105,7 +210,7 @@ func (db *configDatabase) ReadLocalDatabase() error {
 				// Unmarshal json data back to struct
 				err = yaml.Unmarshal(splitPkgJson, &splitPkgClone)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				// Force set split package version, revision and URL
@@ -113,13 +218,14 @@ func (db *configDatabase) ReadLocalDatabase() error {
 				splitPkgClone.Revision = entry.Info.Revision
 				splitPkgClone.Url = entry.Info.Url
 
-				// Create entry for split package
+				// Create entry for split package. The installed size of the split package is only known once compiled,
+				// so the installed size of the source package it is compiled from is used instead
 				database.Entries[splitPkg.Name] = &BPMDatabaseEntry{
 					Info:          &splitPkgClone,
 					Filepath:      entry.Filepath,
 					DownloadSize:  entry.DownloadSize,
-					InstalledSize: 0,
-					Database:      database,
+					InstalledSize: entry.InstalledSize,
+					Sha256:        entry.Sha256,
 				}
 
 				// Add virtual packages to database
@@ -135,62 +241,228 @@ func (db *configDatabase) ReadLocalDatabase() error {
 		}
 	}
 
-	BPMDatabases[db.Name] = database
+	return database, nil
+}
 
-	return nil
+// getMirrors returns the database source followed by all mirrors specified directly or in the mirrorlist file
+func (db *configDatabase) getMirrors() ([]string, error) {
+	mirrors := make([]string, 0)
+	if db.Source != "" {
+		mirrors = append(mirrors, db.Source)
+	}
+	mirrors = append(mirrors, db.Mirrors...)
+
+	if db.Mirrorlist != "" {
+		data, err := os.ReadFile(db.Mirrorlist)
+		if err != nil {
+			return nil, fmt.Errorf("could not read mirrorlist: %s", err)
+		}
+
+		for line := range strings.Lines(string(data)) {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			mirrors = append(mirrors, line)
+		}
+	}
+
+	return removeDuplicates(mirrors), nil
 }
 
-func (db *configDatabase) SyncLocalDatabaseFile() error {
+// SyncLocalDatabaseFile retrieves the database from the first working mirror and returns whether the local database was updated
+func (db *configDatabase) SyncLocalDatabaseFile(verbose bool) (bool, error) {
+	return db.syncLocalDatabaseFile(verbose, nil)
+}
+
+func (db *configDatabase) syncLocalDatabaseFile(verbose bool, progress *multiProgress) (bool, error) {
 	dbFile := "/var/lib/bpm/databases/" + db.Name + ".bpmdb"
+	validatorsFile := dbFile + ".validators"
 
-	// Get URL to database
-	u, err := url.JoinPath(db.Source, "database.bpmdb")
+	mirrors, err := db.getMirrors()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Retrieve data from URL
-	req, err := http.NewRequest("GET", u, nil)
+	verificationLevel, err := parseVerificationLevel(db.VerificationLevel)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	client, err := getHTTPClient(getHTTPSettings(db))
 	if err != nil {
-		return err
+		return false, fmt.Errorf("could not create HTTP client: %s", err)
 	}
-	defer resp.Body.Close()
 
-	// Create progress bar
-	bar := createProgressBar(resp.ContentLength, "Syncing "+db.Name, false)
+	// Read validators of local database
+	validators := &databaseValidators{}
+	if _, err := os.Stat(dbFile); err == nil {
+		if data, err := os.ReadFile(validatorsFile); err == nil {
+			yaml.Unmarshal(data, validators)
+		}
+	}
 
-	// Copy data
-	var buffer bytes.Buffer
-	_, err = io.Copy(io.MultiWriter(&buffer, bar), resp.Body)
-	if err != nil {
-		return err
+	// Get generation time of local database to prevent rollbacks
+	var localGeneratedOn int64 = 0
+	if localDatabase, err := readDatabaseFile(dbFile); err == nil {
+		localGeneratedOn = localDatabase.GeneratedOn
 	}
 
-	// Unmarshal data to ensure it is a valid BPM database
-	err = yaml.Unmarshal(buffer.Bytes(), &BPMDatabase{})
+	var data []byte
+	unchanged := false
+	newValidators := &databaseValidators{}
+	syncDatabaseFile := func(mirror, filename string) error {
+		// Get URL to database
+		u, err := url.JoinPath(mirror, filename)
+		if err != nil {
+			return err
+		}
+
+		// Send validators if local database was retrieved from the same mirror
+		options := urlRequestOptions{}
+		if validators.Mirror == mirror && validators.Filename == filename {
+			options.ETag = validators.ETag
+			options.LastModified = validators.LastModified
+		}
+
+		// Retrieve data from URL or local directory
+		resp, err := openURLWithOptions(context.Background(), client, u, options)
+		if err != nil {
+			return err
+		}
+		if resp.NotModified {
+			unchanged = true
+			return nil
+		}
+		defer resp.Body.Close()
+		newValidators = &databaseValidators{Mirror: mirror, Filename: filename, ETag: resp.ETag, LastModified: resp.LastModified}
+
+		// Create progress bar
+		bar := progress.createProgressBar(resp.Size, "Syncing "+db.Name, false)
+
+		// Copy data
+		var buffer bytes.Buffer
+		_, err = io.Copy(io.MultiWriter(&buffer, bar), resp.Body)
+		if err != nil {
+			bar.Exit()
+			return err
+		}
+		bar.Close()
+
+		// Retrieve and verify database signature if required
+		if verificationLevel != VerificationLevelNone {
+			sigBody, _, err := openURL(context.Background(), client, u+".sig")
+			if err != nil {
+				return fmt.Errorf("could not retrieve database signature: %s", err)
+			}
+			defer sigBody.Close()
+
+			signature, err := io.ReadAll(sigBody)
+			if err != nil {
+				return fmt.Errorf("could not retrieve database signature: %s", err)
+			}
+
+			err = verifyDataSignature(buffer.Bytes(), signature, verificationLevel == VerificationLevelTrusted, "/")
+			if err != nil {
+				return fmt.Errorf("could not verify database signature: %s", err)
+			}
+		}
+
+		// Ensure data is a valid BPM database which is not older than the local one
+		err = checkSyncedDatabase(buffer.Bytes(), localGeneratedOn)
+		if err != nil {
+			return err
+		}
+
+		data = buffer.Bytes()
+		return nil
+	}
+
+	// Retrieve the first available database variant from the first working mirror
+	mirror, err := fetchFromMirrors(context.Background(), mirrors, verbose, progress, func(mirror string) error {
+		for i, filename := range databaseFilenames {
+			err := syncDatabaseFile(mirror, filename)
+			if err != nil && isNotFoundErr(err) && i < len(databaseFilenames)-1 {
+				continue
+			}
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("could not decode database: %s", err)
+		return false, err
+	}
+	if unchanged {
+		if verbose {
+			progress.Printf("Database (%s) on mirror (%s) has not been modified\n", db.Name, mirror)
+		}
+
+		// Generate binary cache of local database if it is missing or outdated
+		if data, err := os.ReadFile(dbFile); err == nil {
+			err = updateDatabaseCache(db.Name, data)
+			if err != nil && verbose {
+				progress.Printf("Warning: could not generate cache for database (%s): %s\n", db.Name, err)
+			}
+		}
+
+		return false, nil
+	}
+	if verbose {
+		progress.Printf("Database (%s) was synced from mirror (%s)\n", db.Name, mirror)
 	}
 
 	// Create parent directories to database file
 	err = os.MkdirAll(path.Dir(dbFile), 0755)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Create file and save database data
-	out, err := os.Create(dbFile)
+	// Replace database file
+	err = writeFileAtomic(dbFile, data, 0644)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer out.Close()
 
-	_, err = out.Write(buffer.Bytes())
+	// Generate binary cache of new database
+	err = updateDatabaseCache(db.Name, data)
+	if err != nil && verbose {
+		progress.Printf("Warning: could not generate cache for database (%s): %s\n", db.Name, err)
+	}
+
+	// Save validators for conditional requests on the next sync
+	os.Remove(validatorsFile)
+	if newValidators.ETag != "" || newValidators.LastModified != "" {
+		validatorsData, err := yaml.Marshal(newValidators)
+		if err != nil {
+			return true, err
+		}
+		err = writeFileAtomic(validatorsFile, validatorsData, 0644)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// checkSyncedDatabase ensures retrieved database data is a valid BPM database generated no earlier than the local database
+// to prevent rollback attacks
+func checkSyncedDatabase(data []byte, localGeneratedOn int64) error {
+	// Decompress and unmarshal data to ensure it is a valid BPM database
+	decompressed, err := decompressData(data)
+	if err != nil {
+		return fmt.Errorf("could not decompress database: %s", err)
+	}
+	database := &BPMDatabase{}
+	err = yaml.Unmarshal(decompressed, database)
+	if err != nil {
+		return fmt.Errorf("could not decode database: %s", err)
+	}
+
+	// Ensure database is not older than the local one
+	if database.GeneratedOn < localGeneratedOn {
+		return DatabaseOutdatedErr{GeneratedOn: database.GeneratedOn, LocalGeneratedOn: localGeneratedOn}
+	}
 
 	return nil
 }
@@ -262,33 +534,147 @@ func GetDatabaseVirtualPackageEntry(vpkg string) (providers []*BPMDatabaseEntry)
 	return providers
 }
 
-func (db *BPMDatabase) FetchPackage(pkg string) (string, error) {
+func (db *BPMDatabase) FetchPackage(pkg string, verbose bool) (string, error) {
+	return db.fetchPackage(context.Background(), pkg, verbose, nil)
+}
+
+func (db *BPMDatabase) fetchPackage(ctx context.Context, pkg string, verbose bool, progress *multiProgress) (string, error) {
 	// Check if package exists in database
 	if !db.ContainsPackage(pkg) {
 		return "", errors.New("could not fetch package '" + pkg + "'")
 	}
 
-	// Get package url from database
+	// Fetch package from the first working mirror
 	entry := db.Entries[pkg]
-	u, err := url.JoinPath(db.Source, entry.Filepath)
+	var filepath string
+	mirror, err := fetchFromMirrors(ctx, db.Mirrors, verbose, progress, func(mirror string) (err error) {
+		filepath, err = db.fetchPackageFromMirror(ctx, progress, entry, mirror)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
+	if verbose {
+		progress.Printf("Package (%s) was fetched from mirror (%s)\n", entry.Info.Name, mirror)
+	}
 
-	// Download package from url
-	filepath := path.Join("/var/cache/bpm/fetched/", path.Base(entry.Filepath))
-	err = downloadFile("Downloading "+entry.Info.Name, u, filepath, 0644)
+	return filepath, nil
+}
+
+func (db *BPMDatabase) fetchPackageFromMirror(ctx context.Context, progress *multiProgress, entry *BPMDatabaseEntry, mirror string) (string, error) {
+	// Use package in place if mirror is a local directory
+	if localPath, ok := getLocalSourcePath(mirror); ok {
+		filepath := path.Join(localPath, entry.Filepath)
+		err := entry.verifyFetchedPackage(filepath)
+		if err != nil {
+			return "", err
+		}
+
+		// Verify signature if required
+		if db.VerificationLevel != VerificationLevelNone {
+			err := VerifySignature(filepath, filepath+".sig", db.VerificationLevel == VerificationLevelTrusted, "/")
+			if err != nil {
+				return "", fmt.Errorf("Could not verify signature for %s: %s", filepath, err)
+			}
+		}
+
+		return filepath, nil
+	}
+
+	// Get package url from mirror
+	u, err := url.JoinPath(mirror, entry.Filepath)
 	if err != nil {
 		return "", err
 	}
 
-	// Download and verify signature if required
-	if db.VerificationLevel != VerificationLevelNone {
-		err = downloadFile("", u+".sig", filepath+".sig", 0644)
+	client, err := getHTTPClient(db.httpSettings)
+	if err != nil {
+		return "", fmt.Errorf("could not create HTTP client: %s", err)
+	}
+
+	filepath := path.Join("/var/cache/bpm/fetched/", path.Base(entry.Filepath))
+	// Only reuse previously fetched packages if their checksum can be verified, since a package of the same size may differ
+	cached := entry.Sha256 != "" && entry.verifyFetchedPackage(filepath) == nil
+	if cached {
+		// Reuse previously fetched package
+		bar := progress.createProgressBar(entry.DownloadSize, "Using cached "+entry.Info.Name, false)
+		bar.Set64(entry.DownloadSize)
+		bar.Close()
+	} else {
+		// Download package from url
+		_, err := os.Stat(filepath + ".part")
+		resumed := err == nil
+		err = downloadFile(ctx, client, progress, "Downloading "+entry.Info.Name, u, filepath, 0644)
+		if err != nil {
+			return "", err
+		}
+
+		// Ensure downloaded package matches database entry
+		err = entry.verifyFetchedPackage(filepath)
+		if err != nil && resumed {
+			// Download package again in case the resumed partial file was stale
+			os.Remove(filepath)
+			err = downloadFile(ctx, client, progress, "Downloading "+entry.Info.Name, u, filepath, 0644)
+			if err != nil {
+				return "", err
+			}
+			err = entry.verifyFetchedPackage(filepath)
+		}
+		if err != nil {
+			os.Remove(filepath)
+			return "", err
+		}
+	}
+
+	// Download and verify signature if required, reusing the signature of cached packages if it is valid
+	requireTrusted := db.VerificationLevel == VerificationLevelTrusted
+	if db.VerificationLevel != VerificationLevelNone && (!cached || VerifySignature(filepath, filepath+".sig", requireTrusted, "/") != nil) {
+		err = downloadFile(ctx, client, progress, "", u+".sig", filepath+".sig", 0644)
 		if err != nil {
 			return "", err
 		}
 
+		err := VerifySignature(filepath, filepath+".sig", requireTrusted, "/")
+		if err != nil {
+			// Remove package so it is not reused
+			os.Remove(filepath)
+			os.Remove(filepath + ".sig")
+			return "", fmt.Errorf("Could not verify signature for %s: %s", filepath, err)
+		}
+	}
+
+	return filepath, nil
+}
+
+// getCachedPackage returns a verified copy of the package that is available without network access, either from a
+// local mirror or from previously fetched packages
+func (db *BPMDatabase) getCachedPackage(entry *BPMDatabaseEntry) (string, error) {
+	// Use package from local mirrors
+	var mirrorErr error
+	for _, mirror := range db.Mirrors {
+		if _, ok := getLocalSourcePath(mirror); ok {
+			filepath, err := db.fetchPackageFromMirror(context.Background(), nil, entry, mirror)
+			if err == nil {
+				return filepath, nil
+			} else if !errors.Is(err, os.ErrNotExist) {
+				mirrorErr = err
+			}
+		}
+	}
+
+	// Use previously fetched package
+	filepath := path.Join("/var/cache/bpm/fetched/", path.Base(entry.Filepath))
+	err := entry.verifyFetchedPackage(filepath)
+	if err != nil {
+		// Report packages that exist in a local mirror but could not be verified
+		if mirrorErr != nil {
+			return "", mirrorErr
+		}
+		return "", fmt.Errorf("package (%s) is not in the fetched package cache: %w", entry.Info.Name, os.ErrNotExist)
+	}
+
+	// Verify signature if required
+	if db.VerificationLevel != VerificationLevelNone {
 		err := VerifySignature(filepath, filepath+".sig", db.VerificationLevel == VerificationLevelTrusted, "/")
 		if err != nil {
 			return "", fmt.Errorf("Could not verify signature for %s: %s", filepath, err)
@@ -298,6 +684,30 @@ func (db *BPMDatabase) FetchPackage(pkg string) (string, error) {
 	return filepath, nil
 }
 
+// verifyFetchedPackage ensures the fetched package file matches the size and checksum recorded in the database entry
+func (entry *BPMDatabaseEntry) verifyFetchedPackage(filepath string) error {
+	stat, err := os.Stat(filepath)
+	if err != nil {
+		return err
+	}
+
+	if entry.DownloadSize > 0 && stat.Size() != entry.DownloadSize {
+		return fmt.Errorf("package file (%s) is %d bytes in size instead of %d", filepath, stat.Size(), entry.DownloadSize)
+	}
+
+	if entry.Sha256 != "" {
+		checksum, err := getFileSha256(filepath)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(checksum, entry.Sha256) {
+			return ChecksumMismatchErr{Filename: filepath, Expected: entry.Sha256, Actual: checksum}
+		}
+	}
+
+	return nil
+}
+
 func (entry *BPMDatabaseEntry) GetEntryDependants() (dependants []string) {
 	dependantsMap := make(map[string][]string)
 
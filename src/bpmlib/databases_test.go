@@ -0,0 +1,121 @@
+package bpmlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyFetchedPackage(t *testing.T) {
+	data := []byte("package contents")
+	filename := path.Join(t.TempDir(), "test-1.0-1-any.bpm")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256.Sum256(data)
+	sha256sum := hex.EncodeToString(checksum[:])
+
+	tests := []struct {
+		name         string
+		downloadSize int64
+		sha256       string
+		mismatch     bool
+		fail         bool
+	}{
+		{name: "matching checksum", downloadSize: int64(len(data)), sha256: sha256sum},
+		{name: "uppercase checksum", sha256: strings.ToUpper(sha256sum)},
+		{name: "wrong checksum", downloadSize: int64(len(data)), sha256: strings.Repeat("0", 64), mismatch: true, fail: true},
+		{name: "wrong size", downloadSize: int64(len(data)) + 1, sha256: sha256sum, fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := &BPMDatabaseEntry{DownloadSize: test.downloadSize, Sha256: test.sha256}
+			err := entry.verifyFetchedPackage(filename)
+
+			if !test.fail && err != nil {
+				t.Fatalf("expected package to be verified, got %s", err)
+			} else if test.fail && err == nil {
+				t.Fatal("expected package verification to fail")
+			}
+			if errors.As(err, &ChecksumMismatchErr{}) != test.mismatch {
+				t.Fatalf("expected checksum mismatch error: %t, got %v", test.mismatch, err)
+			}
+		})
+	}
+
+	// Ensure missing files are not verified
+	entry := &BPMDatabaseEntry{Sha256: sha256sum}
+	if err := entry.verifyFetchedPackage(filename + ".missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected missing file error, got %v", err)
+	}
+}
+
+func TestDatabaseExpiry(t *testing.T) {
+	previousDatabases, previousAction := BPMDatabases, MainBPMConfig.ExpiredDatabaseAction
+	t.Cleanup(func() {
+		BPMDatabases, MainBPMConfig.ExpiredDatabaseAction = previousDatabases, previousAction
+	})
+
+	now := time.Now().Unix()
+	BPMDatabases = map[string]*BPMDatabase{
+		"unlimited": {Name: "unlimited"},
+		"valid":     {Name: "valid", ValidUntil: now + 3600},
+		"expired":   {Name: "expired", ValidUntil: now - 3600},
+	}
+	for name, db := range BPMDatabases {
+		if db.IsExpired() != (name == "expired") {
+			t.Fatalf("expected database (%s) expired: %t", name, name == "expired")
+		}
+	}
+
+	// Ensure expired databases are only refused if configured
+	MainBPMConfig.ExpiredDatabaseAction = "warn"
+	if err := checkDatabaseExpiry(); err != nil {
+		t.Fatalf("expected expired database to be allowed, got %s", err)
+	}
+	MainBPMConfig.ExpiredDatabaseAction = "refuse"
+	var expiredErr DatabaseExpiredErr
+	if err := checkDatabaseExpiry(); !errors.As(err, &expiredErr) || !slices.Equal(expiredErr.databases, []string{"expired"}) {
+		t.Fatalf("expected expired database to be refused, got %v", err)
+	}
+}
+
+func TestCheckSyncedDatabaseRollback(t *testing.T) {
+	localGeneratedOn := time.Now().Unix()
+
+	tests := []struct {
+		name        string
+		generatedOn int64
+		outdated    bool
+	}{
+		{name: "newer database", generatedOn: localGeneratedOn + 60},
+		{name: "same database", generatedOn: localGeneratedOn},
+		{name: "older database", generatedOn: localGeneratedOn - 60, outdated: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := fmt.Sprintf("database_version: %d\ngenerated_on: %d\nentries: {}\n", databaseVersion, test.generatedOn)
+			err := checkSyncedDatabase([]byte(data), localGeneratedOn)
+
+			if test.outdated && !errors.As(err, &DatabaseOutdatedErr{}) {
+				t.Fatalf("expected older database to be rejected, got %v", err)
+			} else if !test.outdated && err != nil {
+				t.Fatalf("expected database to be accepted, got %s", err)
+			}
+		})
+	}
+
+	// Ensure invalid databases are rejected
+	if err := checkSyncedDatabase([]byte("not a database"), localGeneratedOn); err == nil {
+		t.Fatal("expected invalid database to be rejected")
+	}
+}
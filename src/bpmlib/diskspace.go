@@ -0,0 +1,338 @@
+package bpmlib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// diskBlockSize is the block size assumed when estimating the space taken up by files
+const diskBlockSize = 4096
+
+// getAllocatedSize returns the space taken up by a file of the given size, rounded up to whole blocks
+func getAllocatedSize(size int64) int64 {
+	return (size + diskBlockSize - 1) / diskBlockSize * diskBlockSize
+}
+
+// filesystemUsage tracks the space an operation requires on a single filesystem
+type filesystemUsage struct {
+	mountPoint string
+	current    int64
+	peak       int64
+}
+
+// diskUsageTracker accumulates the space required on every filesystem touched by an operation
+type diskUsageTracker struct {
+	filesystems map[uint64]*filesystemUsage
+	devices     map[string]uint64
+}
+
+func newDiskUsageTracker() *diskUsageTracker {
+	return &diskUsageTracker{
+		filesystems: make(map[uint64]*filesystemUsage),
+		devices:     make(map[string]uint64),
+	}
+}
+
+// getDevice returns the device of the filesystem the given path is or would be stored on
+func (tracker *diskUsageTracker) getDevice(filepath string) (uint64, error) {
+	// Find nearest existing directory
+	dir := path.Clean(filepath)
+	for {
+		if device, ok := tracker.devices[dir]; ok {
+			return device, nil
+		}
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			break
+		}
+		if dir == "/" || dir == "." {
+			return 0, fmt.Errorf("could not find existing parent directory of path (%s)", filepath)
+		}
+		dir = path.Dir(dir)
+	}
+
+	stat := syscall.Stat_t{}
+	err := syscall.Stat(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+	device := uint64(stat.Dev)
+	tracker.devices[dir] = device
+
+	if _, ok := tracker.filesystems[device]; !ok {
+		tracker.filesystems[device] = &filesystemUsage{mountPoint: getMountPoint(dir, device)}
+	}
+
+	return device, nil
+}
+
+// getMountPoint returns the topmost parent directory of dir which is stored on the given device
+func getMountPoint(dir string, device uint64) string {
+	for dir != "/" {
+		stat := syscall.Stat_t{}
+		if err := syscall.Stat(path.Dir(dir), &stat); err != nil || uint64(stat.Dev) != device {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	return dir
+}
+
+// add records that size bytes will be written to (or freed from if negative) the filesystem storing the given path
+func (tracker *diskUsageTracker) add(filepath string, size int64) error {
+	device, err := tracker.getDevice(filepath)
+	if err != nil {
+		return err
+	}
+
+	usage := tracker.filesystems[device]
+	usage.current += size
+	usage.peak = max(usage.peak, usage.current)
+
+	return nil
+}
+
+// CheckDiskSpace ensures every filesystem written to by the operation has enough free space for the package cache,
+// the compilation directory and the files installed into the root directory. If downloadOnly is true, only space
+// required to download packages is checked
+func (operation *BPMOperation) CheckDiskSpace(downloadOnly bool) error {
+	tracker := newDiskUsageTracker()
+
+	compilationDir := "/var/cache/bpm/compilation/"
+	if os.Getuid() != 0 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		compilationDir = path.Join(homeDir, ".cache/bpm/compilation/")
+	}
+	compiledDir := path.Join(operation.RootDir, "/var/cache/bpm/compiled/")
+
+	// Count snapshot taken before the operation modifies the system
+	if !downloadOnly && MainBPMConfig.Snapshots != nil && MainBPMConfig.Snapshots.Backend == "copy" {
+		size, err := operation.getCopySnapshotSize()
+		if err != nil {
+			return fmt.Errorf("could not get snapshot size: %s", err)
+		}
+		err = tracker.add(getSnapshotDirectory(operation.RootDir), size)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Split packages share their package file, which is only downloaded and compiled once
+	seenFiles := make(map[string]bool)
+
+	for _, action := range operation.Actions {
+		switch action := action.(type) {
+		case *FetchPackageAction:
+			entry := action.DatabaseEntry
+			firstOfFile := !seenFiles[entry.Filepath]
+			seenFiles[entry.Filepath] = true
+
+			// Count packages that are not already in the fetched package cache
+			if !operation.Offline && firstOfFile {
+				fetchedPackage := path.Join("/var/cache/bpm/fetched/", path.Base(entry.Filepath))
+				if stat, err := os.Stat(fetchedPackage); err != nil || stat.Size() != entry.DownloadSize {
+					err := tracker.add(fetchedPackage, entry.DownloadSize)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			if downloadOnly {
+				continue
+			}
+
+			// Count compilation files and compiled package of source packages
+			if entry.Info.Type == "source" && firstOfFile {
+				err := tracker.add(compilationDir, entry.InstalledSize)
+				if err != nil {
+					return err
+				}
+				err = tracker.add(compiledDir, entry.InstalledSize)
+				if err != nil {
+					return err
+				}
+				err = tracker.add(compilationDir, -entry.InstalledSize)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Count installed files, which are unknown until the package is fetched
+			err := tracker.add(operation.RootDir, entry.InstalledSize)
+			if err != nil {
+				return err
+			}
+			if IsPackageInstalled(entry.Info.Name, operation.RootDir) {
+				err := operation.addPackageFiles(tracker, GetPackage(entry.Info.Name, operation.RootDir), -1)
+				if err != nil {
+					return err
+				}
+			}
+		case *InstallPackageAction:
+			if downloadOnly {
+				continue
+			}
+			bpmpkg := action.BpmPackage
+			firstOfFile := !seenFiles[action.File]
+			seenFiles[action.File] = true
+
+			// Count compilation files and compiled package of source packages
+			if bpmpkg.PkgInfo.Type == "source" {
+				if firstOfFile {
+					err := tracker.add(compilationDir, bpmpkg.GetInstalledSize())
+					if err != nil {
+						return err
+					}
+					err = tracker.add(compiledDir, bpmpkg.GetInstalledSize())
+					if err != nil {
+						return err
+					}
+					err = tracker.add(compilationDir, -bpmpkg.GetInstalledSize())
+					if err != nil {
+						return err
+					}
+				}
+
+				err := tracker.add(operation.RootDir, bpmpkg.GetInstalledSize())
+				if err != nil {
+					return err
+				}
+			} else {
+				err := operation.addPackageFiles(tracker, bpmpkg, 1)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Old package files are removed after new files are extracted
+			pkgName := bpmpkg.PkgInfo.Name
+			if bpmpkg.PkgInfo.IsSplitPackage() {
+				pkgName = action.SplitPackageToInstall
+			}
+			if IsPackageInstalled(pkgName, operation.RootDir) {
+				err := operation.addPackageFiles(tracker, GetPackage(pkgName, operation.RootDir), -1)
+				if err != nil {
+					return err
+				}
+			}
+		case *RemovePackageAction:
+			err := operation.addPackageFiles(tracker, action.BpmPackage, -1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Compare required space with available space on each filesystem
+	insufficient := make([]string, 0)
+	for _, usage := range tracker.filesystems {
+		if usage.peak <= 0 {
+			continue
+		}
+
+		stat := syscall.Statfs_t{}
+		err := syscall.Statfs(usage.mountPoint, &stat)
+		if err != nil {
+			return fmt.Errorf("could not get free space of filesystem (%s): %s", usage.mountPoint, err)
+		}
+
+		available := int64(stat.Bavail) * int64(stat.Bsize)
+		if usage.peak > available {
+			insufficient = append(insufficient, fmt.Sprintf("%s (%s required, %s available)", usage.mountPoint, BytesToHumanReadable(usage.peak), BytesToHumanReadable(available)))
+		}
+	}
+
+	if len(insufficient) != 0 {
+		return InsufficientDiskSpaceErr{insufficient}
+	}
+
+	return nil
+}
+
+// getCopySnapshotSize returns the size of the snapshot the copy snapshot backend takes before executing the operation,
+// which contains the persistent data directory and the existing files affected by the operation
+func (operation *BPMOperation) getCopySnapshotSize() (int64, error) {
+	var size int64
+
+	// Get size of persistent data directory
+	err := filepath.WalkDir(path.Join(operation.RootDir, "var/lib/bpm"), func(filepath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() && isExcludedFromSnapshot(operation.RootDir, filepath) {
+			return fs.SkipDir
+		}
+		if dirEntry.IsDir() {
+			size += diskBlockSize
+		} else if dirEntry.Type().IsRegular() {
+			info, err := dirEntry.Info()
+			if err != nil {
+				return err
+			}
+			size += getAllocatedSize(info.Size())
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	// Get paths affected by the operation
+	paths := make(map[string]bool)
+	addInstalledPackage := func(pkgName string) {
+		if bpmpkg := GetPackage(pkgName, operation.RootDir); bpmpkg != nil {
+			for _, entry := range bpmpkg.PkgFiles {
+				paths[entry.Path] = true
+			}
+		}
+	}
+	for _, action := range operation.Actions {
+		switch action := action.(type) {
+		case *FetchPackageAction:
+			addInstalledPackage(action.DatabaseEntry.Info.Name)
+		case *InstallPackageAction:
+			for _, entry := range action.BpmPackage.PkgFiles {
+				paths[entry.Path] = true
+			}
+			if action.BpmPackage.PkgInfo.IsSplitPackage() {
+				addInstalledPackage(action.SplitPackageToInstall)
+			} else {
+				addInstalledPackage(action.BpmPackage.PkgInfo.Name)
+			}
+		case *RemovePackageAction:
+			addInstalledPackage(action.BpmPackage.PkgInfo.Name)
+		}
+	}
+
+	// Get size of existing files
+	for snapshotPath := range paths {
+		if stat, err := os.Lstat(path.Join(operation.RootDir, snapshotPath)); err == nil && stat.Mode().IsRegular() {
+			size += getAllocatedSize(stat.Size())
+		}
+	}
+
+	return size, nil
+}
+
+// addPackageFiles records the size of every file of a package multiplied by sign on the filesystem storing it
+func (operation *BPMOperation) addPackageFiles(tracker *diskUsageTracker, bpmpkg *BPMPackage, sign int64) error {
+	for _, entry := range bpmpkg.PkgFiles {
+		if entry.SizeInBytes == 0 {
+			continue
+		}
+
+		err := tracker.add(path.Join(operation.RootDir, entry.Path), sign*getAllocatedSize(entry.SizeInBytes))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"time"
 )
 
 type PackageNotFoundErr struct {
@@ -24,6 +25,42 @@ func (e DependencyNotFoundErr) Error() string {
 	return "The following dependencies were not found in any databases: " + strings.Join(e.dependencies, ", ")
 }
 
+type PackagesUnavailableOfflineErr struct {
+	packages []string
+}
+
+func (e PackagesUnavailableOfflineErr) Error() string {
+	slices.Sort(e.packages)
+	return "The following packages are not available offline: " + strings.Join(e.packages, ", ")
+}
+
+type InsufficientDiskSpaceErr struct {
+	filesystems []string
+}
+
+func (e InsufficientDiskSpaceErr) Error() string {
+	slices.Sort(e.filesystems)
+	return "The following filesystems do not have enough free space: " + strings.Join(e.filesystems, ", ")
+}
+
+type PackageVersionsNotCachedErr struct {
+	packages []string
+}
+
+func (e PackageVersionsNotCachedErr) Error() string {
+	slices.Sort(e.packages)
+	return "The following package versions are no longer cached: " + strings.Join(e.packages, ", ")
+}
+
+type PackagesChangedSinceOperationErr struct {
+	packages []string
+}
+
+func (e PackagesChangedSinceOperationErr) Error() string {
+	slices.Sort(e.packages)
+	return "The following packages were changed after the operation: " + strings.Join(e.packages, ", ")
+}
+
 type PackageConflictErr struct {
 	pkg       string
 	conflicts []string
@@ -52,3 +89,104 @@ type PackageRemovalDependencyErr struct {
 func (e PackageRemovalDependencyErr) Error() string {
 	return "removing these package would break other installed packages"
 }
+
+type PackageDependantVersionErr struct {
+	BrokenDependants map[string][]string
+}
+
+func (e PackageDependantVersionErr) Error() string {
+	return "installing these package versions would break other installed packages"
+}
+
+type ConfigError struct {
+	Filename string
+	Line     int
+	Message  string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Filename, e.Message)
+}
+
+type ConfigValidationErr struct {
+	Problems []ConfigError
+}
+
+func (e ConfigValidationErr) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.Error()
+	}
+	return "the following problems were found in the BPM configuration:\n" + strings.Join(problems, "\n")
+}
+
+type MirrorErr struct {
+	Mirror string
+	Err    error
+}
+
+func (e MirrorErr) Error() string {
+	return fmt.Sprintf("%s: %s", e.Mirror, e.Err)
+}
+
+type AllMirrorsFailedErr struct {
+	Errors []MirrorErr
+}
+
+func (e AllMirrorsFailedErr) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = "  " + err.Error()
+	}
+	return "all mirrors failed:\n" + strings.Join(errs, "\n")
+}
+
+type ChecksumMismatchErr struct {
+	Filename string
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumMismatchErr) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected sha256 %s but got %s", e.Filename, e.Expected, e.Actual)
+}
+
+type DatabaseOutdatedErr struct {
+	GeneratedOn      int64
+	LocalGeneratedOn int64
+}
+
+func (e DatabaseOutdatedErr) Error() string {
+	return fmt.Sprintf("database generated on %s is older than the local database generated on %s", time.Unix(e.GeneratedOn, 0).Format(time.DateTime), time.Unix(e.LocalGeneratedOn, 0).Format(time.DateTime))
+}
+
+type DatabaseExpiredErr struct {
+	databases []string
+}
+
+func (e DatabaseExpiredErr) Error() string {
+	slices.Sort(e.databases)
+	return "The following databases have expired and need to be synced: " + strings.Join(e.databases, ", ")
+}
+
+type HTTPStatusErr struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e HTTPStatusErr) Error() string {
+	return fmt.Sprintf("server returned status '%s' for %s", e.Status, e.URL)
+}
+
+type DatabaseSyncErr struct {
+	Failed []string
+	Total  int
+}
+
+func (e DatabaseSyncErr) Error() string {
+	return fmt.Sprintf("%d of %d databases could not be synced: %s", len(e.Failed), e.Total, strings.Join(e.Failed, ", "))
+}
@@ -9,10 +9,12 @@ import (
 	"path"
 	"slices"
 	"strings"
+	"sync"
+	"text/tabwriter"
 )
 
 // InstallPackages installs the specified packages into the given root directory by fetching them from databases or directly from local bpm archives
-func InstallPackages(rootDir string, forceInstallationReason InstallationReason, reinstallPackages bool, installRuntimeDependencies, forceInstallation, runChecks bool, verbose bool, packages ...string) (operation *BPMOperation, err error) {
+func InstallPackages(rootDir string, forceInstallationReason InstallationReason, reinstallPackages bool, installRuntimeDependencies, forceInstallation, runChecks, offline bool, verbose bool, packages ...string) (operation *BPMOperation, err error) {
 	// Setup operation struct
 	operation = &BPMOperation{
 		Actions:           make([]OperationAction, 0),
@@ -20,15 +22,38 @@ func InstallPackages(rootDir string, forceInstallationReason InstallationReason,
 		ModifiedFiles:     make(map[string]string),
 		RunChecks:         runChecks,
 		RootDir:           rootDir,
+		Offline:           offline,
 		compiledPackages:  make(map[string]string),
 	}
 
+	// Check for expired databases
+	err = checkDatabaseExpiry()
+	if err != nil {
+		return nil, err
+	}
+
 	// Remove duplicates from packages
 	packages = removeDuplicates(packages)
 
 	// Resolve packages
 	pkgsNotFound := make([]string, 0)
 	for _, pkg := range packages {
+		// Find exact package version in all databases and package caches
+		var exactEntry *BPMDatabaseEntry
+		if pkgName, comparison, version := SplitPkgNameAndVersion(pkg); comparison == "=" && !strings.HasSuffix(version, "*") {
+			if _, err := os.Stat(pkg); os.IsNotExist(err) {
+				entry, file, err := findPackageVersion(rootDir, pkgName, version)
+				if err != nil {
+					return nil, fmt.Errorf("could not search for package (%s): %s", pkg, err)
+				}
+				if file != "" {
+					pkg = file
+				} else {
+					exactEntry = entry
+				}
+			}
+		}
+
 		if stat, err := os.Stat(pkg); err == nil && !stat.IsDir() {
 			bpmpkg, err := ReadPackage(pkg)
 			if err != nil {
@@ -86,7 +111,9 @@ func InstallPackages(rootDir string, forceInstallationReason InstallationReason,
 
 			var entry *BPMDatabaseEntry
 
-			if e, _, err := GetDatabaseEntry(pkgName); err == nil {
+			if exactEntry != nil {
+				entry = exactEntry
+			} else if e, _, err := GetDatabaseEntry(pkgName); err == nil {
 				entry = e
 			} else if providers := GetVirtualPackageInfo(pkgName, rootDir); len(providers) > 0 {
 				entry, _, err = GetDatabaseEntry(providers[0].Name)
@@ -101,7 +128,7 @@ func InstallPackages(rootDir string, forceInstallationReason InstallationReason,
 				continue
 			}
 
-			if !EvaluateDependency(pkg, entry.Info.Version) {
+			if exactEntry == nil && !EvaluateDependency(pkg, entry.Info.Version) {
 				pkgsNotFound = append(pkgsNotFound, pkg)
 				continue
 			}
@@ -159,6 +186,16 @@ func InstallPackages(rootDir string, forceInstallationReason InstallationReason,
 		}
 	}
 
+	// Check whether installed packages require other versions of packages
+	if broken := operation.CheckDependantVersions(); len(broken) > 0 {
+		err = PackageDependantVersionErr{broken}
+		if !forceInstallation {
+			return nil, err
+		} else {
+			log.Printf("Warning: %s", err)
+		}
+	}
+
 	// Check whether compiling source packages on different root directory
 	if rootDir != "/" {
 		sourcePackages := make([]string, 0)
@@ -181,6 +218,168 @@ func InstallPackages(rootDir string, forceInstallationReason InstallationReason,
 		}
 	}
 
+	// Ensure all packages are available without network access
+	if offline {
+		err = operation.CheckOfflineAvailability()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return operation, nil
+}
+
+// UndoOperation creates an operation reverting the successful actions of the recorded operation with the given ID using
+// previous package versions from the fetched and compiled package caches
+func UndoOperation(rootDir string, id int, force bool) (operation *BPMOperation, err error) {
+	entry, err := GetHistoryEntry(rootDir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	operation = &BPMOperation{
+		Actions:           make([]OperationAction, 0),
+		UnresolvedDepends: make([]string, 0),
+		ModifiedFiles:     make(map[string]string),
+		RootDir:           rootDir,
+		compiledPackages:  make(map[string]string),
+	}
+
+	// Revert actions in reverse order
+	notCached := make([]string, 0)
+	changed := make([]string, 0)
+	for i := len(entry.Actions) - 1; i >= 0; i-- {
+		action := entry.Actions[i]
+		if action.Outcome != "success" {
+			continue
+		}
+
+		// Skip reinstalled packages if their installation reason did not change
+		if action.Type == "reinstall" && (action.OldInstallationReason == "" || action.OldInstallationReason == action.InstallationReason) {
+			continue
+		}
+
+		// Ensure package was not changed by a later operation
+		installedVersion := "not installed"
+		if pkgInfo := GetPackageInfo(action.Package, rootDir); pkgInfo != nil {
+			installedVersion = pkgInfo.GetFullVersion() + " installed"
+		}
+		expectedVersion := "not installed"
+		if action.Type != "remove" {
+			expectedVersion = action.NewVersion + " installed"
+		}
+		if installedVersion != expectedVersion {
+			changed = append(changed, fmt.Sprintf("%s (%s instead of %s)", action.Package, installedVersion, expectedVersion))
+		}
+
+		switch action.Type {
+		case "install":
+			// Remove newly installed package
+			bpmpkg := GetPackage(action.Package, rootDir)
+			if bpmpkg == nil {
+				continue
+			}
+			operation.Actions = append(operation.Actions, &RemovePackageAction{BpmPackage: bpmpkg})
+		case "upgrade", "downgrade", "reinstall", "remove":
+			// Get previous installation reason
+			installationReason := action.InstallationReason
+			if action.Type != "remove" && action.OldInstallationReason != "" {
+				installationReason = action.OldInstallationReason
+			}
+
+			// Find previous package version in package caches
+			cachedPackages, err := GetCachedPackageVersions(rootDir, action.Package)
+			if err != nil {
+				return nil, err
+			}
+			index := slices.IndexFunc(cachedPackages, func(cachedPackage CachedPackage) bool {
+				return cachedPackage.Version == action.OldVersion
+			})
+			if index == -1 {
+				notCached = append(notCached, fmt.Sprintf("%s (%s)", action.Package, action.OldVersion))
+				continue
+			}
+
+			// Reinstall previous package version
+			bpmpkg, err := ReadPackage(cachedPackages[index].Filepath)
+			if err != nil {
+				return nil, fmt.Errorf("could not read package (%s): %s", cachedPackages[index].Filepath, err)
+			}
+			installAction := &InstallPackageAction{
+				File:               cachedPackages[index].Filepath,
+				InstallationReason: PackageLocalInfo{InstallationReason: installationReason}.GetInstallationReason(),
+				BpmPackage:         bpmpkg,
+			}
+			if bpmpkg.PkgInfo.IsSplitPackage() {
+				installAction.SplitPackageToInstall = action.Package
+			}
+			operation.Actions = append(operation.Actions, installAction)
+		}
+	}
+
+	// Return error if packages were changed after the operation, since reverting it would undo those changes as well
+	if len(changed) != 0 {
+		if !force {
+			return nil, PackagesChangedSinceOperationErr{changed}
+		}
+		log.Printf("Warning: %s", PackagesChangedSinceOperationErr{changed})
+	}
+
+	// Return error if previous package versions are not cached
+	if len(notCached) != 0 {
+		return nil, PackageVersionsNotCachedErr{notCached}
+	}
+
+	// Check for conflicts
+	conflicts := operation.CheckForConflicts()
+	if len(conflicts) > 0 {
+		err = fmt.Errorf("conflicts detected")
+		for pkg, conflict := range conflicts {
+			err = errors.Join(err, PackageConflictErr{pkg, conflict})
+		}
+		if !force {
+			return nil, err
+		} else {
+			log.Printf("Warning: %s", err)
+		}
+	}
+
+	// Check whether installed packages require other versions of packages
+	if broken := operation.CheckDependantVersions(); len(broken) > 0 {
+		err = PackageDependantVersionErr{broken}
+		if !force {
+			return nil, err
+		} else {
+			log.Printf("Warning: %s", err)
+		}
+	}
+
+	// Return error if other packages depend on removed ones
+	requiredPackages := make(map[string][]string)
+	for _, action := range operation.Actions {
+		removeAction, ok := action.(*RemovePackageAction)
+		if !ok {
+			continue
+		}
+
+		dependants := slices.DeleteFunc(removeAction.BpmPackage.PkgInfo.GetPackageDependants(rootDir, true), func(dependant string) bool {
+			return slices.ContainsFunc(operation.Actions, func(action OperationAction) bool {
+				return action.GetActionType() == "remove" && action.(*RemovePackageAction).BpmPackage.PkgInfo.Name == dependant
+			})
+		})
+		if len(dependants) > 0 {
+			requiredPackages[removeAction.BpmPackage.PkgInfo.Name] = dependants
+		}
+	}
+	if len(requiredPackages) > 0 {
+		err = PackageRemovalDependencyErr{requiredPackages}
+		if !force {
+			return nil, err
+		} else {
+			log.Printf("Warning: %s", err)
+		}
+	}
+
 	return operation, nil
 }
 
@@ -364,10 +563,13 @@ func CleanupCache(rootDir string, cleanupCompilationFiles, cleanupCompiledPackag
 }
 
 // UpdatePackages fetches the newest versions of all installed packages from
-func UpdatePackages(rootDir string, syncDatabase, allowDowngrades, forceInstallation, runChecks, verbose bool) (operation *BPMOperation, err error) {
+func UpdatePackages(rootDir string, syncDatabase, allowDowngrades, forceInstallation, runChecks, offline, verbose bool) (operation *BPMOperation, err error) {
 	// Sync databases
-	if syncDatabase {
-		err := SyncDatabase(verbose)
+	if syncDatabase && offline {
+		return nil, errors.New("cannot sync databases in offline mode")
+	} else if syncDatabase {
+		results, err := SyncDatabase(verbose)
+		ShowSyncResults(results)
 		if err != nil {
 			return nil, fmt.Errorf("could not sync local database: %s", err)
 		}
@@ -386,6 +588,12 @@ func UpdatePackages(rootDir string, syncDatabase, allowDowngrades, forceInstalla
 		}
 	}
 
+	// Check for expired databases
+	err = checkDatabaseExpiry()
+	if err != nil {
+		return nil, err
+	}
+
 	// Get installed packages and check for updates
 	pkgs, err := GetInstalledPackages(rootDir)
 	if err != nil {
@@ -398,6 +606,7 @@ func UpdatePackages(rootDir string, syncDatabase, allowDowngrades, forceInstalla
 		ModifiedFiles:     make(map[string]string),
 		RunChecks:         runChecks,
 		RootDir:           rootDir,
+		Offline:           offline,
 		compiledPackages:  make(map[string]string),
 	}
 
@@ -540,21 +749,97 @@ func UpdatePackages(rootDir string, syncDatabase, allowDowngrades, forceInstalla
 		}
 	}
 
+	// Check whether installed packages require other versions of packages
+	if broken := operation.CheckDependantVersions(); len(broken) > 0 {
+		err = PackageDependantVersionErr{broken}
+		if !forceInstallation {
+			return nil, err
+		} else {
+			log.Printf("Warning: %s", err)
+		}
+	}
+
+	// Ensure all packages are available without network access
+	if offline {
+		err = operation.CheckOfflineAvailability()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return operation, nil
 }
 
-// SyncDatabase syncs all databases declared in /etc/bpm.conf
-func SyncDatabase(verbose bool) (err error) {
-	for _, db := range MainBPMConfig.Databases {
-		if verbose {
-			fmt.Printf("Fetching package database file for database (%s)...\n", db.Name)
-		}
+type DatabaseSyncResult struct {
+	Name    string
+	Updated bool
+	Err     error
+}
 
-		err := db.SyncLocalDatabaseFile()
-		if err != nil {
-			return err
+// SyncDatabase syncs all databases declared in /etc/bpm.conf concurrently and returns the result for each database
+func SyncDatabase(verbose bool) ([]DatabaseSyncResult, error) {
+	results := make([]DatabaseSyncResult, len(MainBPMConfig.Databases))
+	progress := newMultiProgress()
+
+	// Sync databases using up to the configured number of parallel downloads
+	semaphore := make(chan struct{}, max(MainBPMConfig.ParallelDownloads, 1))
+	var wg sync.WaitGroup
+	for i, db := range MainBPMConfig.Databases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if verbose {
+				progress.Printf("Fetching package database file for database (%s)...\n", db.Name)
+			}
+
+			updated, err := db.syncLocalDatabaseFile(verbose, progress)
+			results[i] = DatabaseSyncResult{Name: db.Name, Updated: updated, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	// Collect failed databases
+	failed := make([]string, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Name)
 		}
 	}
+	if len(failed) > 0 {
+		return results, DatabaseSyncErr{Failed: failed, Total: len(results)}
+	}
 
-	return nil
+	return results, nil
+}
+
+// ShowSyncResults prints a table containing the result of syncing each database
+func ShowSyncResults(results []DatabaseSyncResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 6, 4, 6, ' ', 0)
+	fmt.Fprintln(writer, "Database\tResult")
+	for _, result := range results {
+		if result.Err != nil {
+			// Print multi-line errors on a single line
+			lines := strings.Split(result.Err.Error(), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimSpace(lines[i])
+			}
+			reason := lines[0]
+			if len(lines) > 1 {
+				reason += " " + strings.Join(lines[1:], "; ")
+			}
+			fmt.Fprintf(writer, "%s\tfailed: %s\n", result.Name, reason)
+		} else if result.Updated {
+			fmt.Fprintf(writer, "%s\tupdated\n", result.Name)
+		} else {
+			fmt.Fprintf(writer, "%s\tunchanged\n", result.Name)
+		}
+	}
+	writer.Flush()
 }
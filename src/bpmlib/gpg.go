@@ -1,6 +1,7 @@
 package bpmlib
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -214,3 +215,123 @@ func VerifySignature(filename, signature string, requireTrusted bool, rootDir st
 
 	return err
 }
+
+// SignFile creates a detached signature of the given file using the user's GPG keyring and the given key, or the default key if empty
+func SignFile(filename, signature, keyID string) error {
+	args := []string{"--batch", "--yes", "--detach-sign", "--output", signature}
+	if keyID != "" {
+		args = append(args, "--local-user", keyID)
+	}
+	args = append(args, filename)
+
+	cmd := exec.Command("gpg", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// verifyDataSignature verifies a detached signature of data that has not been written to its final location yet
+func verifyDataSignature(data, signature []byte, requireTrusted bool, rootDir string) error {
+	dir, err := os.MkdirTemp("", "bpm-verify-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.WriteFile(path.Join(dir, "data"), data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(dir, "data.sig"), signature, 0600)
+	if err != nil {
+		return err
+	}
+
+	return VerifySignature(path.Join(dir, "data"), path.Join(dir, "data.sig"), requireTrusted, rootDir)
+}
+
+// getSignatureKey returns the fingerprint of the primary key that created a valid signature, using the given GPG home
+// directory or the user's keyring if empty
+func getSignatureKey(filename, signature, gpgHomedir string) (string, error) {
+	args := []string{"--batch", "--status-fd=1", "--verify", signature, filename}
+	if gpgHomedir != "" {
+		args = append([]string{"--homedir=" + gpgHomedir}, args...)
+	}
+
+	output, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	// Find primary key fingerprint in the last field of the VALIDSIG status line
+	for line := range strings.Lines(string(output)) {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[0] == "[GNUPG:]" && fields[1] == "VALIDSIG" {
+			return fields[len(fields)-1], nil
+		}
+	}
+
+	return "", errors.New("no valid signature found")
+}
+
+// exportKeys returns the armored public keys with the given IDs, using the given GPG home directory or the user's keyring if empty
+func exportKeys(gpgHomedir string, keyIDs ...string) ([]byte, error) {
+	args := []string{"--batch", "--armor", "--export"}
+	if gpgHomedir != "" {
+		args = append([]string{"--homedir=" + gpgHomedir}, args...)
+	}
+	args = append(args, keyIDs...)
+
+	cmd := exec.Command("gpg", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// importKeyFile imports all public keys in the given file into the BPM keyring
+func importKeyFile(rootDir, filename string) error {
+	if !IsKeyringInitialized(rootDir) {
+		return errors.New("keyring needs to be initialized first")
+	}
+
+	cmd := exec.Command("gpg", "--homedir="+path.Join(rootDir, "/var/lib/bpm/gpg"), "--batch", "--import", filename)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// verifyWithKeyFile verifies a signature using a temporary keyring containing only the public keys in the given key file
+// and returns the fingerprints of those keys
+func verifyWithKeyFile(filename, signature, keyFile string) ([]string, error) {
+	gpgHomedir, err := os.MkdirTemp("", "bpm-gpg-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(gpgHomedir)
+
+	err = exec.Command("gpg", "--homedir="+gpgHomedir, "--batch", "--import", keyFile).Run()
+	if err != nil {
+		return nil, fmt.Errorf("could not read public keys: %s", err)
+	}
+
+	_, err = getSignatureKey(filename, signature, gpgHomedir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get fingerprints of primary keys
+	output, err := exec.Command("gpg", "--homedir="+gpgHomedir, "--batch", "--with-colons", "--list-keys").Output()
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := make([]string, 0)
+	primaryKey := false
+	for line := range strings.Lines(string(output)) {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if fields[0] == "pub" {
+			primaryKey = true
+		} else if fields[0] == "fpr" && primaryKey && len(fields) > 9 {
+			fingerprints = append(fingerprints, fields[9])
+			primaryKey = false
+		}
+	}
+
+	return fingerprints, nil
+}
@@ -0,0 +1,248 @@
+package bpmlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type HistoryEntry struct {
+	ID          int              `yaml:"-"`
+	Timestamp   int64            `yaml:"timestamp"`
+	CommandLine []string         `yaml:"command_line"`
+	User        string           `yaml:"user"`
+	Snapshot    int              `yaml:"snapshot,omitempty"`
+	Actions     []*HistoryAction `yaml:"actions"`
+}
+
+type HistoryAction struct {
+	Type                  string `yaml:"type"`
+	Package               string `yaml:"package"`
+	OldVersion            string `yaml:"old_version,omitempty"`
+	NewVersion            string `yaml:"new_version,omitempty"`
+	InstallationReason    string `yaml:"installation_reason,omitempty"`
+	OldInstallationReason string `yaml:"old_installation_reason,omitempty"`
+	Outcome               string `yaml:"outcome"`
+	Error                 string `yaml:"error,omitempty"`
+}
+
+// newHistoryEntry creates a history entry with one action for each action of the operation, all of which are marked as
+// skipped
+func (operation *BPMOperation) newHistoryEntry() *HistoryEntry {
+	entry := &HistoryEntry{
+		Timestamp:   time.Now().Unix(),
+		CommandLine: os.Args,
+		User:        getInvokingUser(),
+		Actions:     make([]*HistoryAction, 0, len(operation.Actions)),
+	}
+
+	for _, action := range operation.Actions {
+		historyAction := &HistoryAction{Outcome: "skipped"}
+
+		switch action := action.(type) {
+		case *InstallPackageAction:
+			pkgInfo := action.BpmPackage.PkgInfo
+			if action.SplitPackageToInstall != "" {
+				for _, splitPkg := range pkgInfo.SplitPackages {
+					if splitPkg.Name == action.SplitPackageToInstall {
+						pkgInfo = splitPkg
+					}
+				}
+			}
+			historyAction.Package = pkgInfo.Name
+			historyAction.NewVersion = pkgInfo.GetFullVersion()
+			historyAction.InstallationReason = string(action.InstallationReason)
+		case *FetchPackageAction:
+			historyAction.Package = action.DatabaseEntry.Info.Name
+			historyAction.NewVersion = action.DatabaseEntry.Info.GetFullVersion()
+			historyAction.InstallationReason = string(action.InstallationReason)
+		case *RemovePackageAction:
+			historyAction.Type = "remove"
+			historyAction.Package = action.BpmPackage.PkgInfo.Name
+			historyAction.OldVersion = action.BpmPackage.PkgInfo.GetFullVersion()
+			historyAction.InstallationReason = string(action.BpmPackage.LocalInfo.GetInstallationReason())
+		}
+
+		// Compare new version with installed version
+		if historyAction.Type == "" {
+			if installedInfo := GetPackageInfo(historyAction.Package, operation.RootDir); installedInfo == nil {
+				historyAction.Type = "install"
+			} else {
+				historyAction.OldVersion = installedInfo.GetFullVersion()
+				historyAction.OldInstallationReason = string(GetPackage(historyAction.Package, operation.RootDir).LocalInfo.GetInstallationReason())
+				switch comparison := CompareVersions(historyAction.NewVersion, historyAction.OldVersion); {
+				case comparison > 0:
+					historyAction.Type = "upgrade"
+				case comparison < 0:
+					historyAction.Type = "downgrade"
+				default:
+					historyAction.Type = "reinstall"
+				}
+			}
+		}
+
+		entry.Actions = append(entry.Actions, historyAction)
+	}
+
+	return entry
+}
+
+// getSummary returns a short description of the actions in the history entry
+func (entry *HistoryEntry) getSummary() string {
+	summary := make([]string, 0, len(entry.Actions))
+	for _, action := range entry.Actions {
+		switch {
+		case action.OldVersion != "" && action.NewVersion != "" && action.OldVersion != action.NewVersion:
+			summary = append(summary, fmt.Sprintf("%s %s (%s -> %s)", action.Type, action.Package, action.OldVersion, action.NewVersion))
+		case action.NewVersion != "":
+			summary = append(summary, fmt.Sprintf("%s %s (%s)", action.Type, action.Package, action.NewVersion))
+		default:
+			summary = append(summary, fmt.Sprintf("%s %s (%s)", action.Type, action.Package, action.OldVersion))
+		}
+	}
+
+	return strings.Join(summary, ", ")
+}
+
+// getInvokingUser returns the name of the user who ran BPM, preferring the user who invoked sudo or doas
+func getInvokingUser() string {
+	for _, env := range []string{"SUDO_USER", "DOAS_USER"} {
+		if username := os.Getenv(env); username != "" {
+			return username
+		}
+	}
+
+	if currentUser, err := user.Current(); err == nil {
+		return currentUser.Username
+	}
+
+	return strconv.Itoa(os.Getuid())
+}
+
+// save writes the history entry to the history directory using the next available ID
+func (entry *HistoryEntry) save(rootDir string) error {
+	historyDir := path.Join(rootDir, "var/lib/bpm/history")
+	err := os.MkdirAll(historyDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Get next history entry ID
+	ids, err := getHistoryIDs(rootDir)
+	if err != nil {
+		return err
+	}
+	entry.ID = 1
+	if len(ids) > 0 {
+		entry.ID = ids[len(ids)-1] + 1
+	}
+
+	file, err := os.OpenFile(path.Join(historyDir, fmt.Sprintf("%d.yml", entry.ID)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// getHistoryIDs returns the IDs of all history entries in ascending order
+func getHistoryIDs(rootDir string) ([]int, error) {
+	dirEntries, err := os.ReadDir(path.Join(rootDir, "var/lib/bpm/history"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name, ok := strings.CutSuffix(dirEntry.Name(), ".yml")
+		if !ok || !dirEntry.Type().IsRegular() {
+			continue
+		}
+		if id, err := strconv.Atoi(name); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// GetHistory returns all recorded operations from oldest to newest
+func GetHistory(rootDir string) ([]*HistoryEntry, error) {
+	ids, err := getHistoryIDs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read history directory: %s", err)
+	}
+
+	history := make([]*HistoryEntry, 0, len(ids))
+	for _, id := range ids {
+		entry, err := GetHistoryEntry(rootDir, id)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
+
+// GetHistoryEntry returns the recorded operation with the given ID
+func GetHistoryEntry(rootDir string, id int) (*HistoryEntry, error) {
+	data, err := os.ReadFile(path.Join(rootDir, "var/lib/bpm/history", fmt.Sprintf("%d.yml", id)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("history entry (%d) does not exist", id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := &HistoryEntry{}
+	err = yaml.Unmarshal(data, entry)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode history entry (%d): %s", id, err)
+	}
+	entry.ID = id
+
+	return entry, nil
+}
+
+// ContainsPackage returns whether any action of the history entry affected the given package
+func (entry *HistoryEntry) ContainsPackage(pkg string) bool {
+	return slices.ContainsFunc(entry.Actions, func(action *HistoryAction) bool {
+		return action.Package == pkg
+	})
+}
+
+// GetOutcome summarizes the outcomes of all actions in the history entry
+func (entry *HistoryEntry) GetOutcome() string {
+	outcome := "success"
+	for _, action := range entry.Actions {
+		if action.Outcome == "failed" {
+			return "failed"
+		} else if action.Outcome != "success" {
+			outcome = "incomplete"
+		}
+	}
+
+	return outcome
+}
@@ -0,0 +1,342 @@
+package bpmlib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type configHTTP struct {
+	Proxy             string `yaml:"proxy"`
+	ConnectTimeout    *int   `yaml:"connect_timeout"`
+	ReadTimeout       *int   `yaml:"read_timeout"`
+	Retries           *int   `yaml:"retries"`
+	RetryBackoff      *int   `yaml:"retry_backoff"`
+	CABundle          string `yaml:"ca_bundle"`
+	ClientCertificate string `yaml:"client_certificate"`
+	ClientKey         string `yaml:"client_key"`
+	CredentialsFile   string `yaml:"credentials_file"`
+	UserAgent         string `yaml:"user_agent"`
+}
+
+// httpSettings contains the HTTP options in effect after applying defaults and database overrides
+type httpSettings struct {
+	Proxy             string
+	ConnectTimeout    int
+	ReadTimeout       int
+	Retries           int
+	RetryBackoff      int
+	CABundle          string
+	ClientCertificate string
+	ClientKey         string
+	CredentialsFile   string
+	UserAgent         string
+}
+
+type httpCredentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+type httpClient struct {
+	client       *http.Client
+	settings     httpSettings
+	readTimeout  time.Duration
+	retryBackoff time.Duration
+	credentials  *httpCredentials
+}
+
+var httpClients = make(map[httpSettings]*httpClient)
+var httpClientsLock sync.Mutex
+
+// apply overrides the current settings with the options set in the given config section
+func (settings *httpSettings) apply(config *configHTTP) {
+	if config == nil {
+		return
+	}
+
+	if config.Proxy != "" {
+		settings.Proxy = config.Proxy
+	}
+	if config.ConnectTimeout != nil {
+		settings.ConnectTimeout = *config.ConnectTimeout
+	}
+	if config.ReadTimeout != nil {
+		settings.ReadTimeout = *config.ReadTimeout
+	}
+	if config.Retries != nil {
+		settings.Retries = *config.Retries
+	}
+	if config.RetryBackoff != nil {
+		settings.RetryBackoff = *config.RetryBackoff
+	}
+	if config.CABundle != "" {
+		settings.CABundle = config.CABundle
+	}
+	if config.ClientCertificate != "" {
+		settings.ClientCertificate = config.ClientCertificate
+		settings.ClientKey = config.ClientKey
+	}
+	if config.CredentialsFile != "" {
+		settings.CredentialsFile = config.CredentialsFile
+	}
+	if config.UserAgent != "" {
+		settings.UserAgent = config.UserAgent
+	}
+}
+
+// getHTTPSettings returns the global HTTP settings overridden by the settings of the given database if it is not nil
+func getHTTPSettings(db *configDatabase) httpSettings {
+	settings := httpSettings{
+		ConnectTimeout: 30,
+		ReadTimeout:    60,
+		Retries:        3,
+		RetryBackoff:   1,
+		UserAgent:      "bpm",
+	}
+	settings.apply(MainBPMConfig.HTTP)
+	if db != nil {
+		settings.apply(db.HTTP)
+	}
+
+	return settings
+}
+
+// getHTTPClient returns a client using the given settings, creating it if it does not exist yet
+func getHTTPClient(settings httpSettings) (*httpClient, error) {
+	httpClientsLock.Lock()
+	defer httpClientsLock.Unlock()
+
+	if client, ok := httpClients[settings]; ok {
+		return client, nil
+	}
+
+	client, err := newHTTPClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	httpClients[settings] = client
+
+	return client, nil
+}
+
+func newHTTPClient(settings httpSettings) (*httpClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Set proxy
+	if settings.Proxy != "" {
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse proxy URL: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Set timeouts
+	connectTimeout := time.Duration(settings.ConnectTimeout) * time.Second
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = time.Duration(settings.ReadTimeout) * time.Second
+
+	// Add CA bundle to system certificates
+	transport.TLSClientConfig = &tls.Config{}
+	if settings.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(settings.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("CA bundle (%s) contains no certificates", settings.CABundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	// Load client certificate
+	if settings.ClientCertificate != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.ClientCertificate, settings.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	// Read credentials
+	var credentials *httpCredentials
+	if settings.CredentialsFile != "" {
+		var err error
+		credentials, err = readHTTPCredentials(settings.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &httpClient{
+		client:       &http.Client{Transport: transport},
+		settings:     settings,
+		readTimeout:  time.Duration(settings.ReadTimeout) * time.Second,
+		retryBackoff: time.Duration(settings.RetryBackoff) * time.Second,
+		credentials:  credentials,
+	}, nil
+}
+
+// readHTTPCredentials reads basic or bearer credentials from a file only accessible by root
+func readHTTPCredentials(filename string) (*httpCredentials, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open credentials file: %s", err)
+	}
+	defer file.Close()
+
+	// Ensure credentials are not readable by other users
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); !ok || sys.Uid != 0 {
+		return nil, fmt.Errorf("credentials file (%s) must be owned by root", filename)
+	}
+	if stat.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("credentials file (%s) must not be accessible by other users (mode %04o)", filename, stat.Mode().Perm())
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &httpCredentials{}
+	err = yaml.Unmarshal(data, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode credentials file (%s): %s", filename, err)
+	}
+
+	if credentials.Token != "" && credentials.Username != "" {
+		return nil, fmt.Errorf("credentials file (%s) must contain either a token or a username, not both", filename)
+	} else if credentials.Token == "" && credentials.Username == "" {
+		return nil, fmt.Errorf("credentials file (%s) contains no token or username", filename)
+	}
+
+	return credentials, nil
+}
+
+// get sends a GET request with the given headers, retrying on connection failures and server errors
+func (client *httpClient) get(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.getOnce(ctx, u, header)
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		// Return response if request succeeded or cannot succeed by retrying
+		var certErr *tls.CertificateVerificationError
+		retry := err != nil && !errors.As(err, &certErr)
+		if err == nil {
+			switch {
+			case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+				retry = true
+			}
+		}
+		if !retry || attempt >= client.settings.Retries {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		// Wait before retrying, doubling the delay after every attempt
+		select {
+		case <-time.After(client.retryBackoff << attempt):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (client *httpClient) getOnce(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("User-Agent", client.settings.UserAgent)
+	if client.credentials != nil {
+		if client.credentials.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+client.credentials.Token)
+		} else {
+			req.SetBasicAuth(client.credentials.Username, client.credentials.Password)
+		}
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+
+	// Cancel request if no data is received within the read timeout
+	body := &timeoutReader{body: resp.Body, ctx: ctx, cancel: cancel, timeout: client.readTimeout}
+	if client.readTimeout > 0 {
+		body.timer = time.AfterFunc(client.readTimeout, body.expire)
+	}
+	resp.Body = body
+
+	return resp, nil
+}
+
+// timeoutReader cancels its request if reading from the response body stalls for longer than the timeout
+type timeoutReader struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+var errReadTimeout = errors.New("timed out waiting for data")
+
+func (reader *timeoutReader) expire() {
+	reader.cancel(errReadTimeout)
+}
+
+func (reader *timeoutReader) Read(p []byte) (int, error) {
+	n, err := reader.body.Read(p)
+	if reader.timer != nil {
+		reader.timer.Reset(reader.timeout)
+	}
+	if err != nil && err != io.EOF && errors.Is(context.Cause(reader.ctx), errReadTimeout) {
+		err = fmt.Errorf("%w after %s", errReadTimeout, reader.timeout)
+	}
+	return n, err
+}
+
+func (reader *timeoutReader) Close() error {
+	if reader.timer != nil {
+		reader.timer.Stop()
+	}
+	err := reader.body.Close()
+	reader.cancel(nil)
+	return err
+}
@@ -1,52 +1,275 @@
 package bpmlib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 )
 
-func downloadFile(barText, u, filepath string, perm os.FileMode) error {
+// getLocalSourcePath returns the filesystem path of the given source if it is an absolute path or a file:// URL
+func getLocalSourcePath(source string) (string, bool) {
+	if strings.HasPrefix(source, "/") {
+		return path.Clean(source), true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", false
+	}
+
+	return path.Clean(u.Path), true
+}
+
+type urlRequestOptions struct {
+	// Offset to start reading from if supported
+	Offset int64
+	// Validators of a previously retrieved copy of the file
+	ETag         string
+	LastModified string
+	// Validator of the partially retrieved copy of the file. Reading starts from the beginning if it does not match
+	IfRange string
+}
+
+type urlResponse struct {
+	Body io.ReadCloser
+	// Full size of the file, or -1 if it is unknown
+	Size int64
+	// Offset reading actually starts from
+	Offset int64
+	// Whether the file matches the validators in the request
+	NotModified  bool
+	ETag         string
+	LastModified string
+}
+
+// openURL opens the file at the given URL for reading and returns its size, or -1 if it is unknown
+func openURL(ctx context.Context, client *httpClient, u string) (io.ReadCloser, int64, error) {
+	resp, err := openURLWithOptions(ctx, client, u, urlRequestOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.Size, nil
+}
+
+// openURLWithOptions opens the file at the given URL for reading, resuming from an offset or skipping
+// files that have not been modified if requested
+func openURLWithOptions(ctx context.Context, client *httpClient, u string, options urlRequestOptions) (*urlResponse, error) {
+	// Open local files directly
+	if localPath, ok := getLocalSourcePath(u); ok {
+		file, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if stat.IsDir() {
+			file.Close()
+			return nil, fmt.Errorf("%s is a directory", localPath)
+		}
+
+		// Compare modification time and size with previous copy
+		etag := fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size())
+		if options.ETag != "" && options.ETag == etag {
+			file.Close()
+			return &urlResponse{NotModified: true, ETag: etag}, nil
+		}
+
+		// Start from the beginning if offset is past the end of the file or the file has changed
+		offset := options.Offset
+		if offset > stat.Size() || (options.IfRange != "" && options.IfRange != etag) {
+			offset = 0
+		}
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return &urlResponse{Body: file, Size: stat.Size(), Offset: offset, ETag: etag}, nil
+	}
+
+	header := make(http.Header)
+	if options.Offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", options.Offset))
+		if options.IfRange != "" {
+			header.Set("If-Range", options.IfRange)
+		}
+	}
+	if options.ETag != "" {
+		header.Set("If-None-Match", options.ETag)
+	}
+	if options.LastModified != "" {
+		header.Set("If-Modified-Since", options.LastModified)
+	}
+
+	resp, err := client.get(ctx, u, header)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &urlResponse{
+		Body:         resp.Body,
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return response, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		response.Body = nil
+		response.NotModified = true
+		return response, nil
+	case http.StatusPartialContent:
+		// Ensure server resumed from the requested offset
+		var start, end, size int64
+		_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size)
+		if err != nil || start != options.Offset {
+			resp.Body.Close()
+			options.Offset = 0
+			return openURLWithOptions(ctx, client, u, options)
+		}
+		response.Size = size
+		response.Offset = options.Offset
+		return response, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Partial file is not part of the current file, start over
+		resp.Body.Close()
+		options.Offset = 0
+		return openURLWithOptions(ctx, client, u, options)
+	default:
+		resp.Body.Close()
+		return nil, HTTPStatusErr{URL: u, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+}
+
+// isNotFoundErr returns whether err was caused by a file not existing locally or on a server
+func isNotFoundErr(err error) bool {
+	var statusErr HTTPStatusErr
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound
+	}
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// fetchFromMirrors calls fetch with each mirror in order until one succeeds and returns the mirror that was used
+func fetchFromMirrors(ctx context.Context, mirrors []string, verbose bool, progress *multiProgress, fetch func(mirror string) error) (string, error) {
+	if len(mirrors) == 0 {
+		return "", errors.New("no sources or mirrors specified")
+	}
+
+	mirrorErrs := make([]MirrorErr, 0, len(mirrors))
+	for i, mirror := range mirrors {
+		err := fetch(mirror)
+		if err == nil {
+			return mirror, nil
+		}
+
+		// Stop trying other mirrors if the operation was cancelled
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		if verbose && i < len(mirrors)-1 {
+			progress.Printf("Could not fetch from mirror (%s): %s\nTrying next mirror...\n", mirror, err)
+		}
+		mirrorErrs = append(mirrorErrs, MirrorErr{Mirror: mirror, Err: err})
+	}
+
+	if len(mirrorErrs) == 1 {
+		return "", mirrorErrs[0].Err
+	}
+	return "", AllMirrorsFailedErr{Errors: mirrorErrs}
+}
+
+// downloadFile downloads the file at the given URL into a partial file, resuming previously interrupted downloads,
+// and renames it to filepath once complete
+func downloadFile(ctx context.Context, client *httpClient, progress *multiProgress, barText, u, filepath string, perm os.FileMode) error {
 	if strings.HasSuffix(filepath, "/") {
 		return fmt.Errorf("Filepath must not end in '/'")
 	}
+	partFilepath := filepath + ".part"
+	validatorFilepath := partFilepath + ".validator"
 
-	req, err := http.NewRequest("GET", u, nil)
+	// Create parent directories
+	err := os.MkdirAll(path.Dir(filepath), 0755)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// Get size and validator of previously downloaded data. Downloads without a validator are not resumed, since the
+	// file may have changed on the server since
+	var offset int64 = 0
+	validator := ""
+	if data, err := os.ReadFile(validatorFilepath); err == nil {
+		validator = strings.TrimSpace(string(data))
+	}
+	if stat, err := os.Stat(partFilepath); err == nil && stat.Mode().IsRegular() && validator != "" {
+		offset = stat.Size()
+	}
+
+	resp, err := openURLWithOptions(ctx, client, u, urlRequestOptions{Offset: offset, IfRange: validator})
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	body, size, offset := resp.Body, resp.Size, resp.Offset
 
-	// Create parent directories
-	err = os.MkdirAll(path.Dir(filepath), 0755)
-	if err != nil {
-		return err
+	// Store validator of the file being downloaded for resuming the download later. Weak entity tags cannot be used for
+	// range requests
+	if offset == 0 {
+		validator = resp.LastModified
+		if resp.ETag != "" && !strings.HasPrefix(resp.ETag, "W/") {
+			validator = resp.ETag
+		}
+		if validator != "" {
+			err = os.WriteFile(validatorFilepath, []byte(validator+"\n"), 0644)
+		} else {
+			err = os.Remove(validatorFilepath)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
-	// Create file
-	file, err := os.Create(filepath)
+	// Open partial file, truncating it if the download could not be resumed
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partFilepath, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
 	// Create progress bar
-	bar := createProgressBar(resp.ContentLength, barText, barText == "")
-	defer bar.Close()
+	bar := progress.createProgressBar(size, barText, barText == "")
+	bar.Set64(offset)
 
 	// Copy data
-	_, err = io.Copy(io.MultiWriter(file, bar), resp.Body)
+	_, err = io.Copy(io.MultiWriter(file, bar), body)
 	if err != nil {
+		bar.Exit()
 		return err
 	}
+	bar.Close()
 
 	// Set file permissions
 	err = file.Chmod(perm)
@@ -54,5 +277,26 @@ func downloadFile(barText, u, filepath string, perm os.FileMode) error {
 		return err
 	}
 
+	// Flush data to disk and move completed file into place
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(partFilepath, filepath)
+	if err != nil {
+		return err
+	}
+
+	// Remove validator of completed download
+	err = os.Remove(validatorFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return nil
 }
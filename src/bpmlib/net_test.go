@@ -0,0 +1,111 @@
+package bpmlib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newTestMirror starts an HTTP server which responds to every request with the given status code and body
+func newTestMirror(t *testing.T, statusCode int, body string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// fetchTestFile returns a fetch function for fetchFromMirrors which reads the given file from a mirror into data
+func fetchTestFile(t *testing.T, filename string, data *string) func(mirror string) error {
+	t.Helper()
+
+	client, err := newHTTPClient(httpSettings{ConnectTimeout: 5, ReadTimeout: 5, UserAgent: "bpm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func(mirror string) error {
+		u, err := url.JoinPath(mirror, filename)
+		if err != nil {
+			return err
+		}
+
+		body, _, err := openURL(context.Background(), client, u)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		contents, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		*data = string(contents)
+
+		return nil
+	}
+}
+
+func TestFetchFromMirrorsFailover(t *testing.T) {
+	missingMirror := newTestMirror(t, http.StatusNotFound, "")
+	brokenMirror := newTestMirror(t, http.StatusInternalServerError, "")
+	workingMirror := newTestMirror(t, http.StatusOK, "database")
+
+	var data string
+	mirror, err := fetchFromMirrors(context.Background(), []string{missingMirror, brokenMirror, workingMirror}, false, nil, fetchTestFile(t, "database.bpmdb", &data))
+	if err != nil {
+		t.Fatalf("could not fetch from mirrors: %s", err)
+	}
+	if mirror != workingMirror {
+		t.Fatalf("expected mirror %s to be used, got %s", workingMirror, mirror)
+	}
+	if data != "database" {
+		t.Fatalf("expected data from working mirror, got %q", data)
+	}
+}
+
+func TestFetchFromMirrorsAllFailed(t *testing.T) {
+	missingMirror := newTestMirror(t, http.StatusNotFound, "")
+	brokenMirror := newTestMirror(t, http.StatusInternalServerError, "")
+
+	var data string
+	_, err := fetchFromMirrors(context.Background(), []string{missingMirror, brokenMirror}, false, nil, fetchTestFile(t, "database.bpmdb", &data))
+
+	// Ensure errors of all mirrors are returned in order
+	var mirrorsErr AllMirrorsFailedErr
+	if !errors.As(err, &mirrorsErr) {
+		t.Fatalf("expected AllMirrorsFailedErr, got %v", err)
+	}
+	if len(mirrorsErr.Errors) != 2 || mirrorsErr.Errors[0].Mirror != missingMirror || mirrorsErr.Errors[1].Mirror != brokenMirror {
+		t.Fatalf("expected errors for both mirrors, got %v", mirrorsErr.Errors)
+	}
+	if !isNotFoundErr(mirrorsErr.Errors[0].Err) {
+		t.Fatalf("expected not found error for missing mirror, got %v", mirrorsErr.Errors[0].Err)
+	}
+}
+
+func TestFetchFromMirrorsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Ensure no other mirrors are tried once cancelled
+	tried := make([]string, 0)
+	_, err := fetchFromMirrors(ctx, []string{"first", "second"}, false, nil, func(mirror string) error {
+		tried = append(tried, mirror)
+		cancel()
+		return errors.New("interrupted")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancellation error, got %v", err)
+	}
+	if len(tried) != 1 {
+		t.Fatalf("expected only the first mirror to be tried, got %v", tried)
+	}
+}
@@ -1,13 +1,16 @@
 package bpmlib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path"
 	"slices"
 	"strings"
+	"sync"
 	"text/tabwriter"
 )
 
@@ -18,6 +21,7 @@ type BPMOperation struct {
 	CompilationJobs   int
 	RunChecks         bool
 	RootDir           string
+	Offline           bool
 
 	compiledPackages   map[string]string
 	hasFetchedPackages bool
@@ -347,6 +351,72 @@ func (operation *BPMOperation) CheckForConflicts() map[string][]string {
 	return conflicts
 }
 
+// CheckDependantVersions returns the version requirements of installed packages that would no longer be met by the
+// packages installed in this operation
+func (operation *BPMOperation) CheckDependantVersions() map[string][]string {
+	broken := make(map[string][]string)
+
+	// Get new package information
+	newPackages := make(map[string]*PackageInfo)
+	for _, action := range operation.Actions {
+		switch action := action.(type) {
+		case *InstallPackageAction:
+			if action.SplitPackageToInstall != "" {
+				for _, splitPkg := range action.BpmPackage.PkgInfo.SplitPackages {
+					if splitPkg.Name == action.SplitPackageToInstall {
+						newPackages[splitPkg.Name] = splitPkg
+					}
+				}
+			} else {
+				newPackages[action.BpmPackage.PkgInfo.Name] = action.BpmPackage.PkgInfo
+			}
+		case *FetchPackageAction:
+			newPackages[action.DatabaseEntry.Info.Name] = action.DatabaseEntry.Info
+		case *RemovePackageAction:
+			newPackages[action.BpmPackage.PkgInfo.Name] = nil
+		}
+	}
+
+	// Get virtual packages provided once this operation is complete
+	virtualPackages := make(map[string]bool)
+	for _, installedPkg := range localPackageInformation[operation.RootDir] {
+		if _, ok := newPackages[installedPkg.Name]; ok {
+			continue
+		}
+		for _, vpkg := range installedPkg.Provides {
+			virtualPackages[vpkg] = true
+		}
+	}
+	for _, pkgInfo := range newPackages {
+		if pkgInfo == nil {
+			continue
+		}
+		for _, vpkg := range pkgInfo.Provides {
+			virtualPackages[vpkg] = true
+		}
+	}
+
+	// Check dependencies of installed packages that are not part of this operation. Like during dependency resolution,
+	// dependencies provided by a virtual package are satisfied regardless of the required version
+	for _, installedPkg := range localPackageInformation[operation.RootDir] {
+		if _, ok := newPackages[installedPkg.Name]; ok {
+			continue
+		}
+
+		for _, depend := range append(slices.Clone(installedPkg.Depends), installedPkg.RuntimeDepends...) {
+			dependName, _, _ := SplitPkgNameAndVersion(depend)
+			if virtualPackages[dependName] {
+				continue
+			}
+			if pkgInfo := newPackages[dependName]; pkgInfo != nil && !EvaluateDependency(depend, pkgInfo.Version) {
+				broken[dependName] = append(broken[dependName], fmt.Sprintf("%s (requires %s)", installedPkg.Name, depend))
+			}
+		}
+	}
+
+	return broken
+}
+
 func (operation *BPMOperation) ShowOperationSummary() {
 	if len(operation.Actions) == 0 {
 		fmt.Println("No action needs to be taken")
@@ -407,7 +477,7 @@ func (operation *BPMOperation) ShowOperationSummary() {
 	if operation.RootDir != "/" {
 		fmt.Println("Warning: Operating in " + operation.RootDir)
 	}
-	if operation.GetTotalDownloadSize() > 0 {
+	if operation.GetTotalDownloadSize() > 0 && !operation.Offline {
 		fmt.Printf("%s will be downloaded to complete this operation\n", BytesToHumanReadable(operation.GetTotalDownloadSize()))
 	}
 	if operation.GetFinalActionSize(operation.RootDir) > 0 {
@@ -420,7 +490,7 @@ func (operation *BPMOperation) ShowOperationSummary() {
 func (operation *BPMOperation) ShowSourcePackageContent() (sourcePackagesShown int, err error) {
 	// Fetch packages
 	if !operation.hasFetchedPackages {
-		err = operation.FetchPackages()
+		err = operation.FetchPackages(false)
 		if err != nil {
 			return 0, err
 		}
@@ -565,15 +635,47 @@ func (operation *BPMOperation) RunPostHooks(verbose bool) error {
 	return nil
 }
 
-func (operation *BPMOperation) FetchPackages() (err error) {
+func (operation *BPMOperation) FetchPackages(verbose bool) (err error) {
+	// Use previously compiled packages instead of fetching source packages when offline
+	if operation.Offline {
+		for i, action := range operation.Actions {
+			if action.GetActionType() != "fetch" || action.(*FetchPackageAction).DatabaseEntry.Info.Type != "source" {
+				continue
+			}
+
+			entry := action.(*FetchPackageAction).DatabaseEntry
+			compiledPackage := getCompiledPackageFilepath(operation.RootDir, entry.Info.Name, entry.Info)
+			if _, err := os.Stat(compiledPackage); err != nil {
+				continue
+			}
+
+			bpmpkg, err := ReadPackage(compiledPackage)
+			if err != nil {
+				return fmt.Errorf("could not read package (%s): %s\n", compiledPackage, err)
+			}
+			operation.Actions[i] = &InstallPackageAction{
+				File:               compiledPackage,
+				InstallationReason: action.(*FetchPackageAction).InstallationReason,
+				BpmPackage:         bpmpkg,
+			}
+		}
+	}
+
 	// Fetch packages from databases
 	if slices.ContainsFunc(operation.Actions, func(action OperationAction) bool {
 		return action.GetActionType() == "fetch"
 	}) {
-		fmt.Println("Fetching packages from available databases...")
+		if operation.Offline {
+			fmt.Println("Retrieving packages from local caches...")
+		} else {
+			fmt.Println("Fetching packages from available databases...")
+		}
 
-		// Create map for fetched packages
-		fetchedPackages := make(map[string]string)
+		// Fetch all packages concurrently
+		fetchedPackages, err := fetchDatabaseEntries(operation.Actions, operation.Offline, verbose)
+		if err != nil {
+			return err
+		}
 
 		for i, action := range operation.Actions {
 			if action.GetActionType() != "fetch" {
@@ -583,41 +685,10 @@ func (operation *BPMOperation) FetchPackages() (err error) {
 			// Get database entry
 			entry := action.(*FetchPackageAction).DatabaseEntry
 
-			// Create bpmpkg variable
-			var bpmpkg *BPMPackage
-
-			// Check if package has already been fetched from download link
-			if fetchedFilepath, ok := fetchedPackages[entry.Filepath]; !ok {
-				// Fetch package from database
-				fetchedPackage, err := entry.Database.FetchPackage(entry.Info.Name)
-				if err != nil {
-					return fmt.Errorf("could not fetch package (%s): %s\n", entry.Info.Name, err)
-				}
-
-				// Read fetched package
-				bpmpkg, err = ReadPackage(fetchedPackage)
-				if err != nil {
-					return fmt.Errorf("could not fetch package (%s): %s\n", entry.Info.Name, err)
-				}
-
-				// Add fetched package to map
-				fetchedPackages[entry.Filepath] = fetchedPackage
-			} else {
-				// Read fetched package
-				bpmpkg, err = ReadPackage(fetchedFilepath)
-				if err != nil {
-					return fmt.Errorf("could not read package (%s): %s\n", entry.Info.Name, err)
-				}
-
-				// Get size of fetched archive
-				stat, err := os.Stat(fetchedFilepath)
-				if err != nil {
-					return err
-				}
-
-				bar := createProgressBar(stat.Size(), "Downloading "+entry.Info.Name, false)
-				bar.Set64(stat.Size())
-				bar.Close()
+			// Read fetched package
+			bpmpkg, err := ReadPackage(fetchedPackages[entry.Filepath])
+			if err != nil {
+				return fmt.Errorf("could not read package (%s): %s\n", entry.Info.Name, err)
 			}
 
 			if bpmpkg.PkgInfo.IsSplitPackage() {
@@ -642,6 +713,143 @@ func (operation *BPMOperation) FetchPackages() (err error) {
 	return nil
 }
 
+// fetchDatabaseEntries downloads the packages of all fetch actions using up to the configured number of parallel downloads,
+// or only retrieves them from local caches if offline
+func fetchDatabaseEntries(actions []OperationAction, offline, verbose bool) (map[string]string, error) {
+	// Get unique package files to fetch
+	entries := make([]*BPMDatabaseEntry, 0)
+	seen := make(map[string]bool)
+	for _, action := range actions {
+		if action.GetActionType() != "fetch" {
+			continue
+		}
+		entry := action.(*FetchPackageAction).DatabaseEntry
+		if !seen[entry.Filepath] {
+			seen[entry.Filepath] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	progress := newMultiProgress()
+	fetchedPackages := make(map[string]string)
+	var fetchErr error
+	var lock sync.Mutex
+
+	// Queue entries in order
+	queue := make(chan *BPMDatabaseEntry)
+	go func() {
+		defer close(queue)
+		for _, entry := range entries {
+			select {
+			case queue <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Start download workers
+	var wg sync.WaitGroup
+	for range min(max(MainBPMConfig.ParallelDownloads, 1), len(entries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+
+				var fetchedPackage string
+				var err error
+				if offline {
+					fetchedPackage, err = entry.Database.getCachedPackage(entry)
+				} else {
+					fetchedPackage, err = entry.Database.fetchPackage(ctx, entry.Info.Name, verbose, progress)
+				}
+
+				lock.Lock()
+				if err != nil {
+					// Keep first error and cancel remaining downloads
+					if fetchErr == nil {
+						fetchErr = fmt.Errorf("could not fetch package (%s): %s\n", entry.Info.Name, err)
+						cancel()
+					}
+				} else {
+					fetchedPackages[entry.Filepath] = fetchedPackage
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return fetchedPackages, nil
+}
+
+// CheckOfflineAvailability ensures all packages in the operation can be retrieved and compiled without network access
+func (operation *BPMOperation) CheckOfflineAvailability() error {
+	unavailable := make([]string, 0)
+	for _, action := range operation.Actions {
+		switch action := action.(type) {
+		case *FetchPackageAction:
+			entry := action.DatabaseEntry
+			if entry.Info.Type == "source" {
+				if _, err := os.Stat(getCompiledPackageFilepath(operation.RootDir, entry.Info.Name, entry.Info)); err == nil {
+					continue
+				}
+			}
+
+			if _, err := entry.Database.getCachedPackage(entry); errors.Is(err, os.ErrNotExist) {
+				unavailable = append(unavailable, entry.Info.Name+" (not cached)")
+			} else if err != nil {
+				unavailable = append(unavailable, entry.Info.Name+" (verification failed)")
+			} else if entry.Info.Type == "source" && len(entry.Info.Downloads) > 0 {
+				unavailable = append(unavailable, entry.Info.Name+" (compilation requires downloads)")
+			}
+		case *InstallPackageAction:
+			pkgInfo := action.BpmPackage.PkgInfo
+			if pkgInfo.Type != "source" {
+				continue
+			}
+
+			pkgName := pkgInfo.Name
+			if pkgInfo.IsSplitPackage() {
+				pkgName = action.SplitPackageToInstall
+			}
+			if _, err := os.Stat(getCompiledPackageFilepath(operation.RootDir, pkgName, pkgInfo)); err == nil {
+				continue
+			}
+
+			if len(pkgInfo.Downloads) > 0 {
+				unavailable = append(unavailable, pkgName+" (compilation requires downloads)")
+			}
+		}
+	}
+
+	if len(unavailable) != 0 {
+		return PackagesUnavailableOfflineErr{unavailable}
+	}
+
+	return nil
+}
+
+// getCompiledPackageFilepath returns the path a source package is stored at after being compiled
+func getCompiledPackageFilepath(rootDir, pkgName string, pkgInfo *PackageInfo) string {
+	outputArch := pkgInfo.OutputArch
+	if outputArch == "" {
+		outputArch = GetArch()
+	}
+
+	return path.Join(rootDir, "/var/cache/bpm/compiled/", fmt.Sprintf("%s-%s-%d-%s.bpm", pkgName, pkgInfo.Version, pkgInfo.Revision, outputArch))
+}
+
 func (operation *BPMOperation) GetModifiedFiles() {
 	// Get modified files
 	for _, action := range operation.Actions {
@@ -675,7 +883,7 @@ func (operation *BPMOperation) GetModifiedFiles() {
 func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 	// Fetch packages
 	if !operation.hasFetchedPackages {
-		err = operation.FetchPackages()
+		err = operation.FetchPackages(verbose)
 		if err != nil {
 			return err
 		}
@@ -698,16 +906,42 @@ func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 	if len(words) == 0 {
 		return nil
 	}
+
+	// Take snapshot before modifying the system
+	history := operation.newHistoryEntry()
+	if len(operation.ModifiedFiles) == 0 {
+		operation.GetModifiedFiles()
+	}
+	snapshot, err := CreateSnapshot(operation.RootDir, history.getSummary(), slices.Sorted(maps.Keys(operation.ModifiedFiles)))
+	if err != nil {
+		return fmt.Errorf("could not create snapshot: %s", err)
+	} else if snapshot != nil {
+		history.Snapshot = snapshot.ID
+		if verbose {
+			log.Printf("Created snapshot (%d)\n", snapshot.ID)
+		}
+	}
+
+	// Record operation in history once finished
+	defer func() {
+		if err := history.save(operation.RootDir); err != nil {
+			log.Printf("Warning: could not record operation in history: %s", err)
+		}
+	}()
+
 	fmt.Printf("%s packages...\n", strings.Join(words, "/"))
 
 	// Installing/Removing packages from system
-	for _, action := range operation.Actions {
+	for i, action := range operation.Actions {
+		historyAction := history.Actions[i]
 		if action.GetActionType() == "remove" {
 			pkgInfo := action.(*RemovePackageAction).BpmPackage.PkgInfo
 			err := removePackage(pkgInfo.Name, verbose, operation.RootDir)
 			if err != nil {
+				historyAction.Outcome, historyAction.Error = "failed", err.Error()
 				return fmt.Errorf("could not remove package (%s): %s\n", pkgInfo.Name, err)
 			}
+			historyAction.Outcome = "success"
 		} else if action.GetActionType() == "install" {
 			value := action.(*InstallPackageAction)
 			fileToInstall := value.File
@@ -723,6 +957,7 @@ func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 				if _, err := os.Stat(compiledDir); err != nil {
 					err := os.MkdirAll(compiledDir, 0755)
 					if err != nil {
+						historyAction.Outcome, historyAction.Error = "failed", err.Error()
 						return err
 					}
 				}
@@ -733,10 +968,19 @@ func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 					pkgNameToInstall = value.SplitPackageToInstall
 				}
 
+				// Use previously compiled package when offline
+				if _, ok := operation.compiledPackages[pkgNameToInstall]; !ok && operation.Offline {
+					compiledPackage := getCompiledPackageFilepath(operation.RootDir, pkgNameToInstall, bpmpkg.PkgInfo)
+					if _, err := os.Stat(compiledPackage); err == nil {
+						operation.compiledPackages[pkgNameToInstall] = compiledPackage
+					}
+				}
+
 				// Compile source package if not compiled already
 				if _, ok := operation.compiledPackages[pkgNameToInstall]; !ok {
 					outputBpmPackages, err := CompileSourcePackage(value.File, compiledDir, operation.CompilationJobs, !operation.RunChecks, false, verbose)
 					if err != nil {
+						historyAction.Outcome, historyAction.Error = "failed", err.Error()
 						return fmt.Errorf("could not compile source package (%s): %s\n", value.File, err)
 					}
 
@@ -750,8 +994,10 @@ func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 				fileToInstall = operation.compiledPackages[pkgNameToInstall]
 				bpmpkg, err = ReadPackage(fileToInstall)
 				if err != nil {
+					historyAction.Outcome, historyAction.Error = "failed", err.Error()
 					return fmt.Errorf("could not read package (%s): %s\n", fileToInstall, err)
 				}
+				historyAction.NewVersion = bpmpkg.PkgInfo.GetFullVersion()
 			}
 
 			if value.InstallationReason != InstallationReasonManual {
@@ -760,11 +1006,19 @@ func (operation *BPMOperation) Execute(verbose, force bool) (err error) {
 				err = installPackage(fileToInstall, value.InstallationReason, operation.RootDir, verbose, force)
 			}
 			if err != nil {
+				historyAction.Outcome, historyAction.Error = "failed", err.Error()
 				return fmt.Errorf("could not install package (%s): %s\n", bpmpkg.PkgInfo.Name, err)
 			}
+			historyAction.Outcome = "success"
 		}
 	}
 
+	// Remove old cached packages
+	err = ApplyCacheRetention(operation.RootDir, verbose)
+	if err != nil {
+		log.Printf("Warning: could not apply cache retention policy: %s", err)
+	}
+
 	return nil
 }
 
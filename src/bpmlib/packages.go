@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/exec"
 	"path"
@@ -710,24 +711,14 @@ func extractPackage(bpmpkg *BPMPackage, verbose bool, filename, rootDir string)
 				continue
 			}
 
-			if _, err := os.Stat(extractFilename); err == nil {
+			if stat, err := os.Stat(extractFilename); err == nil && stat.IsDir() {
 				if verbose {
 					fmt.Printf("Skipping Directory: %s (Directory already exists)\n", extractFilename)
 				}
 				continue
 			}
 
-			if err := os.Mkdir(extractFilename, 0755); err != nil && !os.IsExist(err) {
-				return err
-			}
-
-			err = os.Chown(extractFilename, header.Uid, header.Gid)
-			if err != nil {
-				return err
-			}
-
-			// Using syscall instead of os.Chmod because it seems to strip the setuid, setgid and sticky bits
-			err := syscall.Chmod(extractFilename, uint32(header.Mode))
+			err := extractDirectory(header, extractFilename)
 			if err != nil {
 				return err
 			}
@@ -773,29 +764,8 @@ func extractPackage(bpmpkg *BPMPackage, verbose bool, filename, rootDir string)
 			if skip {
 				continue
 			}
-			err := os.Remove(extractFilename)
-			if err != nil && !os.IsNotExist(err) {
-				return err
-			}
-			outFile, err := os.Create(extractFilename)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(outFile, packageFilesReader); err != nil {
-				return err
-			}
-			err = outFile.Close()
-			if err != nil {
-				return err
-			}
 
-			err = os.Chown(extractFilename, header.Uid, header.Gid)
-			if err != nil {
-				return err
-			}
-
-			// Using syscall instead of os.Chmod because it seems to strip the setuid, setgid and sticky bits
-			err = syscall.Chmod(extractFilename, uint32(header.Mode))
+			err := extractRegularFile(packageFilesReader, header, extractFilename)
 			if err != nil {
 				return err
 			}
@@ -816,12 +786,7 @@ func extractPackage(bpmpkg *BPMPackage, verbose bool, filename, rootDir string)
 				continue
 			}
 
-			err := os.Remove(extractFilename)
-			if err != nil && !os.IsNotExist(err) {
-				return err
-			}
-
-			err = os.Symlink(header.Linkname, extractFilename)
+			err := extractSymlink(header, extractFilename)
 			if err != nil {
 				return err
 			}
@@ -846,17 +811,19 @@ func extractPackage(bpmpkg *BPMPackage, verbose bool, filename, rootDir string)
 				fmt.Println("Detected Hard Link: " + extractFilename + " -> " + path.Join(rootDir, strings.TrimPrefix(header.Linkname, "files/")))
 			}
 			seenHardlinks[extractFilename] = path.Join(strings.TrimPrefix(header.Linkname, "files/"))
-			err := os.Remove(extractFilename)
-			if err != nil && !os.IsNotExist(err) {
-				return err
-			}
 			bar.Add64(header.Size)
 		default:
 			return errors.New("unknown type (" + strconv.Itoa(int(header.Typeflag)) + ") in " + extractFilename)
 		}
 	}
 	for extractFilename, destination := range seenHardlinks {
-		err := os.Link(path.Join(rootDir, destination), extractFilename)
+		// Create hard link under a temporary name and rename it over the existing file
+		tempPath := getTemporaryPath(extractFilename)
+		err := os.Link(path.Join(rootDir, destination), tempPath)
+		if err != nil {
+			return err
+		}
+		err = replacePath(tempPath, extractFilename)
 		if err != nil {
 			return err
 		}
@@ -870,6 +837,170 @@ func extractPackage(bpmpkg *BPMPackage, verbose bool, filename, rootDir string)
 	return nil
 }
 
+// getTemporaryPath returns an unused path in the same directory as the given path for atomically replacing it
+func getTemporaryPath(target string) string {
+	return path.Join(path.Dir(target), fmt.Sprintf(".%s.bpm-%s", path.Base(target), strconv.FormatUint(rand.Uint64(), 36)))
+}
+
+// replacePath renames the temporary path over the target path, which is first removed if it is an empty directory
+// since directories cannot be replaced by renaming. The temporary path is removed if it cannot be renamed
+func replacePath(tempPath, target string) error {
+	if stat, err := os.Lstat(target); err == nil && stat.IsDir() {
+		err := os.Remove(target)
+		if err != nil {
+			os.RemoveAll(tempPath)
+			return err
+		}
+	}
+
+	err := os.Rename(tempPath, target)
+	if err != nil {
+		os.RemoveAll(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+// extractDirectory creates a directory under a temporary name with its final owner and mode and renames it to the
+// target path, replacing any file that is not a directory
+func extractDirectory(header *tar.Header, target string) error {
+	if stat, err := os.Lstat(target); err == nil && !stat.IsDir() {
+		err := os.Remove(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	tempPath := getTemporaryPath(target)
+	err := os.Mkdir(tempPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chown(tempPath, header.Uid, header.Gid)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Using syscall instead of os.Chmod because it seems to strip the setuid, setgid and sticky bits
+	err = syscall.Chmod(tempPath, uint32(header.Mode))
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return replacePath(tempPath, target)
+}
+
+// extractRegularFile writes a file to a temporary path and renames it over the target path once its contents are
+// synced to disk and its owner and mode are set, so the target is never missing or partially written
+func extractRegularFile(reader io.Reader, header *tar.Header, target string) error {
+	tempFile, err := os.CreateTemp(path.Dir(target), "."+path.Base(target)+".bpm-*")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+
+	if _, err := io.Copy(tempFile, reader); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	err = tempFile.Sync()
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	err = tempFile.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Chown(tempPath, header.Uid, header.Gid)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Using syscall instead of os.Chmod because it seems to strip the setuid, setgid and sticky bits
+	err = syscall.Chmod(tempPath, uint32(header.Mode))
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return replacePath(tempPath, target)
+}
+
+// extractSymlink creates a symlink under a temporary path and renames it over the target path
+func extractSymlink(header *tar.Header, target string) error {
+	tempPath := getTemporaryPath(target)
+	err := os.Symlink(header.Linkname, tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Lchown(tempPath, header.Uid, header.Gid)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return replacePath(tempPath, target)
+}
+
+// removeReplacedDirectories removes directories of the installed package version which the new package version replaces
+// with a file or symlink, along with all entries the installed package version has inside them
+func removeReplacedDirectories(bpmpkg *BPMPackage, rootDir string, verbose bool) error {
+	oldEntries := GetPackage(bpmpkg.PkgInfo.Name, rootDir).PkgFiles
+
+	for _, entry := range bpmpkg.PkgFiles {
+		if strings.HasSuffix(entry.Path, "/") {
+			continue
+		}
+		dirPath := path.Join(rootDir, entry.Path)
+		if stat, err := os.Lstat(dirPath); err != nil || !stat.IsDir() {
+			continue
+		}
+
+		// Get old package entries inside directory from deepest to shallowest
+		toRemove := make([]string, 0)
+		for _, oldEntry := range oldEntries {
+			if strings.HasPrefix(oldEntry.Path, entry.Path+"/") && oldEntry.Path != entry.Path+"/" {
+				toRemove = append(toRemove, strings.TrimSuffix(oldEntry.Path, "/"))
+			}
+		}
+		slices.Sort(toRemove)
+		slices.Reverse(toRemove)
+
+		for _, oldPath := range toRemove {
+			finalPath := path.Join(rootDir, oldPath)
+			if verbose {
+				fmt.Println("Removing: " + finalPath)
+			}
+			// Directories still containing files of other packages cause removing the replaced directory to fail below
+			err := os.Remove(finalPath)
+			if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTEMPTY) {
+				return err
+			}
+		}
+
+		if verbose {
+			fmt.Println("Removing: " + dirPath)
+		}
+		err := os.Remove(dirPath)
+		if err != nil {
+			return fmt.Errorf("could not replace directory (%s): %s", dirPath, err)
+		}
+	}
+
+	return nil
+}
+
 func installPackage(filename string, installationReason InstallationReason, rootDir string, verbose, force bool) error {
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		return err
@@ -884,6 +1015,12 @@ func installPackage(filename string, installationReason InstallationReason, root
 		return fmt.Errorf("can only extract binary packages")
 	}
 
+	if !force {
+		if bpmpkg.PkgInfo.Arch != "any" && bpmpkg.PkgInfo.Arch != GetArch() {
+			return errors.New("cannot install a package with a different architecture")
+		}
+	}
+
 	packageInstalled := IsPackageInstalled(bpmpkg.PkgInfo.Name, rootDir)
 
 	// Run pre-* package scripts
@@ -899,7 +1036,25 @@ func installPackage(filename string, installationReason InstallationReason, root
 		}
 	}
 
-	// Check if package is installed and remove current files
+	// Remove directories replaced by files or symlinks, which cannot be renamed over
+	if packageInstalled {
+		err := removeReplacedDirectories(bpmpkg, rootDir, verbose)
+		if err != nil {
+			return err
+		}
+	}
+
+	if verbose {
+		fmt.Printf("Extracting files for package (%s)...\n", bpmpkg.PkgInfo.Name)
+	}
+
+	// Extract package files into rootDir, replacing existing files in place
+	err = extractPackage(bpmpkg, verbose, filename, rootDir)
+	if err != nil {
+		return err
+	}
+
+	// Check if package is installed and remove files no longer provided by the package
 	if packageInstalled {
 		// Fetching and reversing package file entry list
 		fileEntries := GetPackage(bpmpkg.PkgInfo.Name, rootDir).PkgFiles
@@ -912,11 +1067,21 @@ func installPackage(filename string, installationReason InstallationReason, root
 			return err
 		}
 
+		// Get paths provided by the new package version
+		newPaths := make(map[string]bool)
+		for _, entry := range bpmpkg.PkgFiles {
+			newPaths[strings.TrimSuffix(entry.Path, "/")] = true
+		}
+
 		// Removing old package files
 		if verbose {
 			fmt.Printf("Removing old files for package (%s)...\n", bpmpkg.PkgInfo.Name)
 		}
 		for _, entry := range fileEntries {
+			if newPaths[strings.TrimSuffix(entry.Path, "/")] {
+				continue
+			}
+
 			finalPath := path.Join(rootDir, entry.Path)
 
 			stat, err := os.Lstat(finalPath)
@@ -1006,21 +1171,6 @@ func installPackage(filename string, installationReason InstallationReason, root
 			}
 		}
 	}
-	if !force {
-		if bpmpkg.PkgInfo.Arch != "any" && bpmpkg.PkgInfo.Arch != GetArch() {
-			return errors.New("cannot install a package with a different architecture")
-		}
-	}
-
-	if verbose {
-		fmt.Printf("Extracting files for package (%s)...\n", bpmpkg.PkgInfo.Name)
-	}
-
-	// Extract package files into rootDir
-	err = extractPackage(bpmpkg, verbose, filename, rootDir)
-	if err != nil {
-		return err
-	}
 
 	installedDir := path.Join(rootDir, "var/lib/bpm/installed/")
 	err = os.MkdirAll(installedDir, 0755)
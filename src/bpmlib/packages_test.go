@@ -0,0 +1,235 @@
+package bpmlib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testPackageFile is a file, directory (path ending with '/') or symlink (non-empty linkname) inside a test package
+type testPackageFile struct {
+	path     string
+	linkname string
+	content  string
+}
+
+// writeTestPackage writes a binary package containing the given files to the given directory and returns its path
+func writeTestPackage(t *testing.T, dir, name, version string, files []testPackageFile) string {
+	t.Helper()
+
+	// Create files tarball and file list
+	filesTarball := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(filesTarball)
+	filesWriter := tar.NewWriter(gzipWriter)
+	fileList := &strings.Builder{}
+	for _, file := range files {
+		header := &tar.Header{Name: file.path, Uid: os.Getuid(), Gid: os.Getgid()}
+		switch {
+		case strings.HasSuffix(file.path, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		case file.linkname != "":
+			header.Typeflag, header.Mode, header.Linkname = tar.TypeSymlink, 0777, file.linkname
+		default:
+			header.Typeflag, header.Mode, header.Size = tar.TypeReg, 0644, int64(len(file.content))
+		}
+		if err := filesWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := filesWriter.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(fileList, "%s %o %d %d %d\n", file.path, header.Mode, header.Uid, header.Gid, header.Size)
+	}
+	if err := filesWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create package archive
+	info := fmt.Sprintf("name: %s\ndescription: Test package\nversion: %s\nrevision: 1\nurl: https://example.com\nlicense: MIT\ntype: binary\narchitecture: any\n", name, version)
+	pkgFile := &bytes.Buffer{}
+	pkgWriter := tar.NewWriter(pkgFile)
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"info.yml", []byte(info)},
+		{"files.txt", []byte(fileList.String())},
+		{"files.tar.gz", filesTarball.Bytes()},
+	} {
+		if err := pkgWriter.WriteHeader(&tar.Header{Name: member.name, Mode: 0644, Size: int64(len(member.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pkgWriter.Write(member.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pkgWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := path.Join(dir, fmt.Sprintf("%s-%s.bpm", name, version))
+	if err := os.WriteFile(filename, pkgFile.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestInstallPackageReplacesDirectoryWithSymlink(t *testing.T) {
+	rootDir := t.TempDir()
+	pkgDir := t.TempDir()
+
+	oldPkg := writeTestPackage(t, pkgDir, "test", "1.0", []testPackageFile{
+		{path: "usr/"},
+		{path: "usr/share/"},
+		{path: "usr/share/test/"},
+		{path: "usr/share/test/data/"},
+		{path: "usr/share/test/data/nested/"},
+		{path: "usr/share/test/data/nested/a.txt", content: "old a"},
+		{path: "usr/share/test/real/"},
+		{path: "usr/share/test/real/b.txt", content: "old b"},
+	})
+	newPkg := writeTestPackage(t, pkgDir, "test", "2.0", []testPackageFile{
+		{path: "usr/"},
+		{path: "usr/share/"},
+		{path: "usr/share/test/"},
+		{path: "usr/share/test/data", linkname: "real"},
+		{path: "usr/share/test/real/"},
+		{path: "usr/share/test/real/b.txt", content: "new b"},
+	})
+
+	if err := installPackage(oldPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not install old package version: %s", err)
+	}
+	if err := installPackage(newPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not upgrade package: %s", err)
+	}
+
+	// Ensure directory was replaced by symlink
+	dataPath := path.Join(rootDir, "usr/share/test/data")
+	stat, err := os.Lstat(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected %s to be a symlink, got mode %s", dataPath, stat.Mode())
+	}
+	if linkname, _ := os.Readlink(dataPath); linkname != "real" {
+		t.Fatalf("expected %s to link to real, got %s", dataPath, linkname)
+	}
+
+	// Ensure files were replaced
+	data, err := os.ReadFile(path.Join(dataPath, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new b" {
+		t.Fatalf("expected new contents through symlink, got %q", data)
+	}
+
+	// Ensure new package version is recorded
+	if info := GetPackageInfo("test", rootDir); info == nil || info.Version != "2.0" {
+		t.Fatalf("expected installed version 2.0, got %v", info)
+	}
+
+	// Ensure no temporary files were left behind
+	entries, err := os.ReadDir(path.Join(rootDir, "usr/share/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".bpm-") {
+			t.Fatalf("temporary file (%s) was left behind", entry.Name())
+		}
+	}
+}
+
+func TestInstallPackageReplacesFileWithDirectory(t *testing.T) {
+	rootDir := t.TempDir()
+	pkgDir := t.TempDir()
+
+	oldPkg := writeTestPackage(t, pkgDir, "test", "1.0", []testPackageFile{
+		{path: "etc/"},
+		{path: "etc/test.conf", content: "old config"},
+	})
+	newPkg := writeTestPackage(t, pkgDir, "test", "2.0", []testPackageFile{
+		{path: "etc/"},
+		{path: "etc/test.conf/"},
+		{path: "etc/test.conf/main.conf", content: "new config"},
+	})
+
+	if err := installPackage(oldPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not install old package version: %s", err)
+	}
+	if err := installPackage(newPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not upgrade package: %s", err)
+	}
+
+	// Ensure file was replaced by directory
+	stat, err := os.Lstat(path.Join(rootDir, "etc/test.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.IsDir() {
+		t.Fatalf("expected etc/test.conf to be a directory, got mode %s", stat.Mode())
+	}
+
+	data, err := os.ReadFile(path.Join(rootDir, "etc/test.conf/main.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new config" {
+		t.Fatalf("expected new contents, got %q", data)
+	}
+}
+
+func TestInstallPackageRemovesOldPaths(t *testing.T) {
+	rootDir := t.TempDir()
+	pkgDir := t.TempDir()
+
+	oldPkg := writeTestPackage(t, pkgDir, "test", "1.0", []testPackageFile{
+		{path: "usr/"},
+		{path: "usr/bin/"},
+		{path: "usr/bin/test", content: "binary"},
+		{path: "usr/bin/test-old", content: "old binary"},
+		{path: "usr/share/"},
+		{path: "usr/share/test/"},
+		{path: "usr/share/test/data.txt", content: "data"},
+	})
+	newPkg := writeTestPackage(t, pkgDir, "test", "2.0", []testPackageFile{
+		{path: "usr/"},
+		{path: "usr/bin/"},
+		{path: "usr/bin/test", content: "new binary"},
+	})
+
+	if err := installPackage(oldPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not install old package version: %s", err)
+	}
+	if err := installPackage(newPkg, InstallationReasonManual, rootDir, false, false); err != nil {
+		t.Fatalf("could not upgrade package: %s", err)
+	}
+
+	// Ensure paths no longer in the package were removed
+	for _, oldPath := range []string{"usr/bin/test-old", "usr/share/test/data.txt", "usr/share/test"} {
+		if _, err := os.Lstat(path.Join(rootDir, oldPath)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got error %v", oldPath, err)
+		}
+	}
+
+	// Ensure paths still in the package were kept
+	data, err := os.ReadFile(path.Join(rootDir, "usr/bin/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new binary" {
+		t.Fatalf("expected new contents, got %q", data)
+	}
+}
@@ -0,0 +1,129 @@
+package bpmlib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// multiProgress displays multiple progress bars at once, each on its own line
+type multiProgress struct {
+	lock      sync.Mutex
+	output    io.Writer
+	terminal  bool
+	lines     []*progressLine
+	committed int
+	drawn     int
+}
+
+type progressLine struct {
+	progress *multiProgress
+	content  []byte
+	done     bool
+}
+
+func newMultiProgress() *multiProgress {
+	return &multiProgress{
+		output:   os.Stderr,
+		terminal: term.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// createProgressBar creates a progress bar drawn on its own line, or a regular progress bar if progress is nil
+func (progress *multiProgress) createProgressBar(max int64, description string, hideBar bool) *progressbar.ProgressBar {
+	if progress == nil || hideBar {
+		return createProgressBar(max, description, hideBar)
+	}
+
+	progress.lock.Lock()
+	line := &progressLine{progress: progress}
+	progress.lines = append(progress.lines, line)
+	progress.lock.Unlock()
+
+	return newProgressBar(max, description, line)
+}
+
+// Printf prints a message above all progress bars that are still in progress
+func (progress *multiProgress) Printf(format string, a ...any) {
+	if progress == nil {
+		fmt.Printf(format, a...)
+		return
+	}
+
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	// Print message on the same stream as the progress bars so it is not overwritten when they are redrawn
+	if !progress.terminal {
+		fmt.Fprintf(progress.output, format, a...)
+		return
+	}
+
+	var buffer bytes.Buffer
+	progress.clear(&buffer)
+	fmt.Fprintf(&buffer, format, a...)
+	progress.output.Write(buffer.Bytes())
+	progress.redraw()
+}
+
+func (line *progressLine) Write(p []byte) (int, error) {
+	progress := line.progress
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	wasDone := line.done
+	for _, b := range p {
+		switch b {
+		case '\r':
+			line.content = line.content[:0]
+		case '\n':
+			line.done = true
+		default:
+			line.content = append(line.content, b)
+		}
+	}
+
+	if !progress.terminal {
+		// Only print lines once their progress bar completes
+		if line.done && !wasDone {
+			fmt.Fprintf(progress.output, "%s\n", strings.TrimSpace(string(line.content)))
+		}
+	} else if line.done || strings.TrimSpace(string(line.content)) != "" {
+		progress.redraw()
+	}
+
+	return len(p), nil
+}
+
+// clear moves the cursor to the first line that is still being drawn and clears all lines below it
+func (progress *multiProgress) clear(buffer *bytes.Buffer) {
+	if progress.drawn > 0 {
+		fmt.Fprintf(buffer, "\033[%dA", progress.drawn)
+	}
+	buffer.WriteString("\r\033[J")
+	progress.drawn = 0
+}
+
+func (progress *multiProgress) redraw() {
+	var buffer bytes.Buffer
+	progress.clear(&buffer)
+
+	for _, line := range progress.lines[progress.committed:] {
+		fmt.Fprintf(&buffer, "%s\n", line.content)
+		progress.drawn++
+	}
+
+	// Stop redrawing completed lines at the top
+	for progress.committed < len(progress.lines) && progress.lines[progress.committed].done {
+		progress.committed++
+		progress.drawn--
+	}
+
+	progress.output.Write(buffer.Bytes())
+}
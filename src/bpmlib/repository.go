@@ -0,0 +1,363 @@
+package bpmlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const databaseVersion = 1
+
+// CreateRepository generates a database file containing all BPM packages found in the given directory
+func CreateRepository(repoDir string, sign bool, signingKey string, validFor time.Duration, verbose bool) error {
+	database := &BPMDatabase{
+		DatabaseVersion: databaseVersion,
+		Entries:         make(map[string]*BPMDatabaseEntry),
+	}
+
+	// Find all packages in repository directory
+	packages := make([]string, 0)
+	err := filepath.WalkDir(repoDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(p, ".bpm") {
+			packages = append(packages, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Add packages to database
+	err = database.addRepositoryPackages(repoDir, packages, sign, signingKey, verbose)
+	if err != nil {
+		return err
+	}
+
+	return database.writeRepositoryDatabase(path.Join(repoDir, "database.bpmdb"), sign, signingKey, validFor)
+}
+
+// AddToRepository adds the given packages to a repository database file, replacing older entries with the same name
+func AddToRepository(dbFile string, packages []string, sign bool, signingKey string, validFor time.Duration, verbose bool) error {
+	dbFile = getRepositoryDatabaseFile(dbFile)
+
+	database, err := readRepositoryDatabase(dbFile)
+	if err != nil {
+		return err
+	}
+
+	err = database.addRepositoryPackages(path.Dir(dbFile), packages, sign, signingKey, verbose)
+	if err != nil {
+		return err
+	}
+
+	return database.writeRepositoryDatabase(dbFile, sign, signingKey, validFor)
+}
+
+// RemoveFromRepository removes the entries of the given packages from a repository database file
+func RemoveFromRepository(dbFile string, packages []string, sign bool, signingKey string, validFor time.Duration, verbose bool) error {
+	dbFile = getRepositoryDatabaseFile(dbFile)
+
+	database, err := readRepositoryDatabase(dbFile)
+	if err != nil {
+		return err
+	}
+
+	pkgsNotFound := make([]string, 0)
+	for _, pkg := range packages {
+		// Find entry containing package as its main or split package
+		entryName := ""
+		for name, entry := range database.Entries {
+			if name == pkg || entry.Info.GetSplitPackageInfo(pkg) != nil {
+				entryName = name
+				break
+			}
+		}
+		if entryName == "" {
+			pkgsNotFound = append(pkgsNotFound, pkg)
+			continue
+		}
+
+		if verbose {
+			if entryName != pkg {
+				fmt.Printf("Removing entry (%s) containing split package (%s)\n", entryName, pkg)
+			} else {
+				fmt.Printf("Removing entry (%s)\n", entryName)
+			}
+		}
+		delete(database.Entries, entryName)
+	}
+	if len(pkgsNotFound) != 0 {
+		slices.Sort(pkgsNotFound)
+		return fmt.Errorf("the following packages were not found in the database: %s", strings.Join(pkgsNotFound, ", "))
+	}
+
+	return database.writeRepositoryDatabase(dbFile, sign, signingKey, validFor)
+}
+
+// getRepositoryDatabaseFile returns the path to the database file inside the given path if it is a directory
+func getRepositoryDatabaseFile(dbFile string) string {
+	if stat, err := os.Stat(dbFile); err == nil && stat.IsDir() {
+		return path.Join(dbFile, "database.bpmdb")
+	}
+	return dbFile
+}
+
+func readRepositoryDatabase(dbFile string) (*BPMDatabase, error) {
+	data, err := os.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	database := &BPMDatabase{}
+	err = yaml.Unmarshal(data, database)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode database: %s", err)
+	}
+	if database.Entries == nil {
+		database.Entries = make(map[string]*BPMDatabaseEntry)
+	}
+	database.DatabaseVersion = databaseVersion
+
+	return database, nil
+}
+
+func (database *BPMDatabase) addRepositoryPackages(repoDir string, packages []string, sign bool, signingKey string, verbose bool) error {
+	addedPackages := make(map[string]string)
+	for _, pkg := range packages {
+		entry, err := createRepositoryEntry(repoDir, pkg)
+		if err != nil {
+			return fmt.Errorf("could not add package (%s) to database: %s", pkg, err)
+		}
+
+		// Skip package if a newer version is already in the database
+		if existing, ok := database.Entries[entry.Info.Name]; ok && existing.Filepath != entry.Filepath && CompareVersions(existing.Info.GetFullVersion(), entry.Info.GetFullVersion()) > 0 {
+			if verbose {
+				fmt.Printf("Skipping package (%s) because a newer version (%s) is already in the database\n", pkg, existing.Info.GetFullVersion())
+			}
+			continue
+		}
+
+		if verbose {
+			fmt.Printf("Adding package (%s) to database as (%s)\n", pkg, entry.Info.Name)
+		}
+		database.Entries[entry.Info.Name] = entry
+		addedPackages[entry.Info.Name] = pkg
+	}
+
+	// Sign added packages
+	if sign {
+		for _, pkg := range addedPackages {
+			err := SignFile(pkg, pkg+".sig", signingKey)
+			if err != nil {
+				return fmt.Errorf("could not sign package (%s): %s", pkg, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func createRepositoryEntry(repoDir, pkg string) (*BPMDatabaseEntry, error) {
+	// Get path to package relative to repository directory
+	absRepoDir, err := filepath.Abs(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	absPkg, err := filepath.Abs(pkg)
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := filepath.Rel(absRepoDir, absPkg)
+	if err != nil {
+		return nil, err
+	}
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return nil, fmt.Errorf("package is not inside the repository directory (%s)", repoDir)
+	}
+
+	// Read and validate package
+	bpmpkg, err := ReadPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read package info as written in the archive to avoid storing defaults for the current system
+	raw, err := GetPackageInfoRaw(pkg)
+	if err != nil {
+		return nil, err
+	}
+	info := &PackageInfo{}
+	err = yaml.Unmarshal([]byte(raw), info)
+	if err != nil {
+		return nil, err
+	}
+	info.Revision = bpmpkg.PkgInfo.Revision
+
+	// Get package archive size and checksum
+	stat, err := os.Stat(pkg)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := getFileSha256(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BPMDatabaseEntry{
+		Info:          info,
+		Filepath:      relPath,
+		DownloadSize:  stat.Size(),
+		InstalledSize: bpmpkg.GetInstalledSize(),
+		Sha256:        checksum,
+	}, nil
+}
+
+func (database *BPMDatabase) writeRepositoryDatabase(dbFile string, sign bool, signingKey string, validFor time.Duration) error {
+	if len(database.Entries) == 0 {
+		return errors.New("no packages to write to database")
+	}
+
+	// Set generation and expiry time
+	now := time.Now()
+	database.GeneratedOn = now.Unix()
+	database.ValidUntil = 0
+	if validFor > 0 {
+		database.ValidUntil = now.Add(validFor).Unix()
+	}
+
+	data, err := yaml.Marshal(database)
+	if err != nil {
+		return err
+	}
+
+	// Create compressed database variants
+	zstdData, err := compressZstd(data)
+	if err != nil {
+		return err
+	}
+	gzipData, err := compressGzip(data)
+	if err != nil {
+		return err
+	}
+	variants := map[string][]byte{
+		dbFile + ".zst": zstdData,
+		dbFile + ".gz":  gzipData,
+		dbFile:          data,
+	}
+
+	for _, filename := range slices.Sorted(maps.Keys(variants)) {
+		// Write database file to a temporary file
+		tempFile, err := writeTemporaryFile(filename, variants[filename], 0644)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tempFile)
+
+		// Sign temporary database file and move the signature into place before the database, so clients never
+		// receive a new database along with an outdated signature
+		if sign {
+			defer os.Remove(tempFile + ".sig")
+			err = SignFile(tempFile, tempFile+".sig", signingKey)
+			if err != nil {
+				return fmt.Errorf("could not sign database: %s", err)
+			}
+			err = os.Rename(tempFile+".sig", filename+".sig")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.Rename(tempFile, filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type repositoryServer struct {
+	root    *os.Root
+	verbose bool
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ServeRepository serves the database, package and signature files in the given directory over HTTP
+func ServeRepository(repoDir, listenAddr string, verbose bool) error {
+	root, err := os.OpenRoot(repoDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	server := &http.Server{
+		Addr:    listenAddr,
+		Handler: &repositoryServer{root: root, verbose: verbose},
+	}
+
+	fmt.Printf("Serving repository (%s) on %s\n", repoDir, listenAddr)
+	return server.ListenAndServe()
+}
+
+func (server *repositoryServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	if server.verbose {
+		defer func() {
+			fmt.Printf("%s %s %s %d\n", req.RemoteAddr, req.Method, req.URL.Path, sw.status)
+		}()
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		sw.Header().Set("Allow", "GET, HEAD")
+		http.Error(sw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Do not serve directories or hidden files such as temporary database files
+	name := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/")
+	if name == "" || strings.HasPrefix(path.Base(name), ".") {
+		http.NotFound(sw, req)
+		return
+	}
+
+	file, err := server.root.Open(name)
+	if err != nil {
+		http.NotFound(sw, req)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		http.NotFound(sw, req)
+		return
+	}
+
+	// Set ETag so conditional and range requests can be validated
+	sw.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()))
+	sw.Header().Set("Content-Type", "application/octet-stream")
+
+	http.ServeContent(sw, req, name, stat.ModTime(), file)
+}
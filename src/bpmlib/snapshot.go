@@ -0,0 +1,461 @@
+package bpmlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type configSnapshots struct {
+	Backend        string `yaml:"backend"`
+	Directory      string `yaml:"directory"`
+	CreateCommand  string `yaml:"create_command"`
+	RestoreCommand string `yaml:"restore_command"`
+	RemoveCommand  string `yaml:"remove_command"`
+	Keep           int    `yaml:"keep"`
+}
+
+// SnapshotBackend creates, restores and removes snapshots taken before operations modify the system. Backends may store
+// their data in the snapshot directory
+type SnapshotBackend interface {
+	CreateSnapshot(rootDir string, snapshot *Snapshot) error
+	RestoreSnapshot(rootDir string, snapshot *Snapshot) error
+	RemoveSnapshot(rootDir string, snapshot *Snapshot) error
+}
+
+type Snapshot struct {
+	ID        int      `yaml:"-"`
+	Timestamp int64    `yaml:"timestamp"`
+	Label     string   `yaml:"label"`
+	Backend   string   `yaml:"backend"`
+	Paths     []string `yaml:"paths"`
+	Directory string   `yaml:"-"`
+}
+
+var snapshotBackends = map[string]SnapshotBackend{
+	"copy":    copySnapshotBackend{},
+	"command": commandSnapshotBackend{},
+}
+
+// RegisterSnapshotBackend makes a snapshot backend available under the given name for use in the BPM config
+func RegisterSnapshotBackend(name string, backend SnapshotBackend) {
+	snapshotBackends[name] = backend
+}
+
+// getSnapshotDirectory returns the directory containing all snapshots in the given root directory
+func getSnapshotDirectory(rootDir string) string {
+	if MainBPMConfig.Snapshots != nil && MainBPMConfig.Snapshots.Directory != "" {
+		return path.Join(rootDir, MainBPMConfig.Snapshots.Directory)
+	}
+	return path.Join(rootDir, "var/lib/bpm/snapshots")
+}
+
+// CreateSnapshot takes a snapshot of the given paths using the configured snapshot backend and removes the oldest
+// snapshots exceeding the configured amount to keep. It returns nil if snapshots are disabled
+func CreateSnapshot(rootDir, label string, paths []string) (*Snapshot, error) {
+	config := MainBPMConfig.Snapshots
+	if config == nil || config.Backend == "" {
+		return nil, nil
+	}
+	backend, ok := snapshotBackends[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown snapshot backend (%s)", config.Backend)
+	}
+
+	// Get next snapshot ID
+	snapshotDir := getSnapshotDirectory(rootDir)
+	err := os.MkdirAll(snapshotDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := getSnapshotIDs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	snapshot := &Snapshot{
+		ID:        id,
+		Timestamp: time.Now().Unix(),
+		Label:     label,
+		Backend:   config.Backend,
+		Paths:     paths,
+		Directory: path.Join(snapshotDir, strconv.Itoa(id)),
+	}
+
+	// Create snapshot
+	err = os.Mkdir(snapshot.Directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = backend.CreateSnapshot(rootDir, snapshot)
+	if err != nil {
+		os.RemoveAll(snapshot.Directory)
+		return nil, err
+	}
+
+	// Write snapshot information
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path.Join(snapshot.Directory, "snapshot.yml"), data, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove old snapshots
+	if config.Keep > 0 && len(ids)+1 > config.Keep {
+		for _, oldID := range ids[:len(ids)+1-config.Keep] {
+			err := RemoveSnapshot(rootDir, oldID)
+			if err != nil {
+				log.Printf("Warning: could not remove old snapshot (%d): %s", oldID, err)
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// getSnapshotIDs returns the IDs of all snapshots in ascending order
+func getSnapshotIDs(rootDir string) ([]int, error) {
+	dirEntries, err := os.ReadDir(getSnapshotDirectory(rootDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		if id, err := strconv.Atoi(dirEntry.Name()); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// GetSnapshots returns all snapshots from oldest to newest
+func GetSnapshots(rootDir string) ([]*Snapshot, error) {
+	ids, err := getSnapshotIDs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot directory: %s", err)
+	}
+
+	snapshots := make([]*Snapshot, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := GetSnapshot(rootDir, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// GetSnapshot returns the snapshot with the given ID
+func GetSnapshot(rootDir string, id int) (*Snapshot, error) {
+	directory := path.Join(getSnapshotDirectory(rootDir), strconv.Itoa(id))
+	data, err := os.ReadFile(path.Join(directory, "snapshot.yml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("snapshot (%d) does not exist", id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	err = yaml.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode snapshot (%d): %s", id, err)
+	}
+	snapshot.ID = id
+	snapshot.Directory = directory
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot restores the snapshot with the given ID using the backend it was created with
+func RestoreSnapshot(rootDir string, id int) error {
+	snapshot, err := GetSnapshot(rootDir, id)
+	if err != nil {
+		return err
+	}
+	backend, ok := snapshotBackends[snapshot.Backend]
+	if !ok {
+		return fmt.Errorf("unknown snapshot backend (%s)", snapshot.Backend)
+	}
+
+	return backend.RestoreSnapshot(rootDir, snapshot)
+}
+
+// RemoveSnapshot removes the snapshot with the given ID using the backend it was created with
+func RemoveSnapshot(rootDir string, id int) error {
+	snapshot, err := GetSnapshot(rootDir, id)
+	if err != nil {
+		return err
+	}
+	backend, ok := snapshotBackends[snapshot.Backend]
+	if !ok {
+		return fmt.Errorf("unknown snapshot backend (%s)", snapshot.Backend)
+	}
+
+	err = backend.RemoveSnapshot(rootDir, snapshot)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(snapshot.Directory)
+}
+
+// copySnapshotBackend copies the BPM persistent data directory and the paths affected by an operation into the
+// snapshot directory
+type copySnapshotBackend struct{}
+
+func (backend copySnapshotBackend) CreateSnapshot(rootDir string, snapshot *Snapshot) error {
+	// Copy persistent data directory
+	err := copySnapshotTree(rootDir, path.Join(rootDir, "var/lib/bpm"), path.Join(snapshot.Directory, "bpm"))
+	if err != nil {
+		return fmt.Errorf("could not copy persistent data directory: %s", err)
+	}
+
+	// Copy affected paths that currently exist
+	for _, snapshotPath := range snapshot.Paths {
+		err := copySnapshotPath(path.Join(rootDir, snapshotPath), path.Join(snapshot.Directory, "files", snapshotPath))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not copy path (%s): %s", snapshotPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (backend copySnapshotBackend) RestoreSnapshot(rootDir string, snapshot *Snapshot) error {
+	// Remove affected paths that did not exist when the snapshot was taken, starting with the deepest ones
+	paths := slices.Clone(snapshot.Paths)
+	slices.Sort(paths)
+	for _, snapshotPath := range slices.Backward(paths) {
+		if _, err := os.Lstat(path.Join(snapshot.Directory, "files", snapshotPath)); err == nil {
+			continue
+		}
+
+		target := path.Join(rootDir, snapshotPath)
+		if stat, err := os.Lstat(target); err == nil && stat.IsDir() {
+			// Keep directories that contain files not affected by the snapshot
+			os.Remove(target)
+		} else if err == nil {
+			err := os.Remove(target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Restore affected paths
+	for _, snapshotPath := range paths {
+		err := copySnapshotPath(path.Join(snapshot.Directory, "files", snapshotPath), path.Join(rootDir, snapshotPath))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not restore path (%s): %s", snapshotPath, err)
+		}
+	}
+
+	// Replace persistent data directory
+	persistentDataDir := path.Join(rootDir, "var/lib/bpm")
+	dirEntries, err := os.ReadDir(persistentDataDir)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		entryPath := path.Join(persistentDataDir, dirEntry.Name())
+		if isExcludedFromSnapshot(rootDir, entryPath) {
+			continue
+		}
+		err := os.RemoveAll(entryPath)
+		if err != nil {
+			return err
+		}
+	}
+	err = copySnapshotTree(rootDir, path.Join(snapshot.Directory, "bpm"), persistentDataDir)
+	if err != nil {
+		return fmt.Errorf("could not restore persistent data directory: %s", err)
+	}
+
+	return nil
+}
+
+func (backend copySnapshotBackend) RemoveSnapshot(rootDir string, snapshot *Snapshot) error {
+	return nil
+}
+
+// isExcludedFromSnapshot returns whether the given path is the history or snapshot directory, which must be kept when
+// restoring snapshots
+func isExcludedFromSnapshot(rootDir, filepath string) bool {
+	return filepath == path.Join(rootDir, "var/lib/bpm/history") || filepath == getSnapshotDirectory(rootDir)
+}
+
+// copySnapshotTree recursively copies a directory, skipping excluded directories
+func copySnapshotTree(rootDir, source, destination string) error {
+	return filepath.WalkDir(source, func(filepath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() && isExcludedFromSnapshot(rootDir, filepath) {
+			return fs.SkipDir
+		}
+
+		relative := strings.TrimPrefix(strings.TrimPrefix(filepath, source), "/")
+		return copySnapshotPath(filepath, path.Join(destination, relative))
+	})
+}
+
+// copySnapshotPath copies a single directory, symlink or regular file while preserving its permissions and ownership.
+// Other file types are skipped
+func copySnapshotPath(source, destination string) error {
+	stat, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	sys, _ := stat.Sys().(*syscall.Stat_t)
+
+	err = os.MkdirAll(path.Dir(destination), 0755)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stat.IsDir():
+		err = os.Mkdir(destination, stat.Mode().Perm())
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	case stat.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		os.Remove(destination)
+		err = os.Symlink(target, destination)
+		if err != nil {
+			return err
+		}
+		if sys != nil {
+			return os.Lchown(destination, int(sys.Uid), int(sys.Gid))
+		}
+		return nil
+	case stat.Mode().IsRegular():
+		err = copyFileContents(source, destination)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	err = os.Chmod(destination, stat.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+	if err != nil {
+		return err
+	}
+	if sys != nil {
+		return os.Lchown(destination, int(sys.Uid), int(sys.Gid))
+	}
+
+	return nil
+}
+
+func copyFileContents(source, destination string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	// Remove destination first so hard links and running executables are not modified in place
+	err = os.Remove(destination)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer destinationFile.Close()
+
+	_, err = io.Copy(destinationFile, sourceFile)
+	if err != nil {
+		return err
+	}
+
+	return destinationFile.Close()
+}
+
+// commandSnapshotBackend runs the commands configured in the BPM config to create, restore and remove snapshots, allowing
+// the use of filesystem or volume manager snapshots
+type commandSnapshotBackend struct{}
+
+func (backend commandSnapshotBackend) CreateSnapshot(rootDir string, snapshot *Snapshot) error {
+	// Write affected paths to a file for use by the command
+	err := os.WriteFile(path.Join(snapshot.Directory, "paths.txt"), []byte(strings.Join(snapshot.Paths, "\n")), 0644)
+	if err != nil {
+		return err
+	}
+
+	return runSnapshotCommand(rootDir, MainBPMConfig.Snapshots.CreateCommand, snapshot)
+}
+
+func (backend commandSnapshotBackend) RestoreSnapshot(rootDir string, snapshot *Snapshot) error {
+	if MainBPMConfig.Snapshots == nil || MainBPMConfig.Snapshots.RestoreCommand == "" {
+		return errors.New("no snapshot restore command is configured")
+	}
+
+	return runSnapshotCommand(rootDir, MainBPMConfig.Snapshots.RestoreCommand, snapshot)
+}
+
+func (backend commandSnapshotBackend) RemoveSnapshot(rootDir string, snapshot *Snapshot) error {
+	if MainBPMConfig.Snapshots == nil || MainBPMConfig.Snapshots.RemoveCommand == "" {
+		return nil
+	}
+
+	return runSnapshotCommand(rootDir, MainBPMConfig.Snapshots.RemoveCommand, snapshot)
+}
+
+func runSnapshotCommand(rootDir, command string, snapshot *Snapshot) error {
+	cmd := exec.Command("/bin/bash", "-c", command)
+	cmd.Dir = "/"
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, fmt.Sprintf("BPM_ROOT=%s", rootDir))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("BPM_SNAPSHOT_ID=%d", snapshot.ID))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("BPM_SNAPSHOT_LABEL=%s", snapshot.Label))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("BPM_SNAPSHOT_DIR=%s", snapshot.Directory))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("BPM_SNAPSHOT_PATHS=%s", path.Join(snapshot.Directory, "paths.txt")))
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("snapshot command failed: %s", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package bpmlib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
@@ -84,6 +86,10 @@ func createProgressBar(max int64, description string, hideBar bool) *progressbar
 		output = os.Stderr
 	}
 
+	return newProgressBar(max, description, output)
+}
+
+func newProgressBar(max int64, description string, output io.Writer) *progressbar.ProgressBar {
 	if len(description) < 40 {
 		for i := len(description); i < 40; i++ {
 			description += " "
@@ -141,3 +147,71 @@ func removeDuplicates[T comparable](sliceList []T) []T {
 	}
 	return list
 }
+
+// writeFileAtomic writes data to a temporary file next to filename and renames it into place
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tempFile, err := writeTemporaryFile(filename, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+
+	return os.Rename(tempFile, filename)
+}
+
+// writeTemporaryFile writes data to a synced temporary file in the same directory as filename, which can then be renamed
+// over it, and returns the path to the temporary file
+func writeTemporaryFile(filename string, data []byte, perm os.FileMode) (string, error) {
+	file, err := os.CreateTemp(path.Dir(filename), "."+path.Base(filename)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// Remove temporary file if it could not be written
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(file.Name())
+		}
+	}()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return "", err
+	}
+
+	err = file.Chmod(perm)
+	if err != nil {
+		return "", err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return "", err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	success = true
+	return file.Name(), nil
+}
+
+func getFileSha256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}